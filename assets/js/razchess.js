@@ -118,8 +118,19 @@ class Game {
         return this.#jrpc.call('Session.Resign', [this.#state.turn]);
     }
 
+    getTags() {
+        return this.#jrpc.call('Session.GetTags', [true]);
+    }
+
+    setTags(tags) {
+        return this.#jrpc.call('Session.SetTags', [tags]);
+    }
+
     update(update) {
         if (!this.#board) {
+            if (update.orientation) {
+                this.#orientation = update.orientation;
+            }
             this.#createBoard();
         } else {
             this.#playSound(update);
@@ -250,6 +261,9 @@ class Menu {
     #$viewers;
     #fen;
     #pgn;
+    #statusHTML;
+    #deadline;
+    #countdown;
 
     constructor(roomID, statusDivID, viewersDivID) {
         this.#sessionURL = window.location.protocol + '//' + window.location.host + '/room/' + roomID;
@@ -269,6 +283,24 @@ class Menu {
         navigator.clipboard.writeText(this.#pgn);
     }
 
+    editGameInfo(game) {
+        game.getTags().then(function(tags) {
+            var changes = {};
+            for (const key of ['White', 'Black', 'Event', 'Round', 'Annotator']) {
+                var value = prompt(key, tags[key] || '');
+                if (value === null) {
+                    return;
+                }
+                if (value != (tags[key] || '')) {
+                    changes[key] = value.trim();
+                }
+            }
+            game.setTags(changes).catch(function(error) {
+                alert(error.message || error);
+            });
+        });
+    }
+
     update(update) {
         this.#fen = update.fen;
         this.#pgn = update.pgn;
@@ -276,9 +308,101 @@ class Menu {
         if (update.opening) {
             html = '<h1>' + update.opening + '</h1> - ' + html;
         }
+        if (update.tablebase) {
+            var tablebase = update.tablebase.text;
+            if (update.tablebase.bestMove) {
+                tablebase += ', best move: ' + update.tablebase.bestMove;
+            }
+            html += ' - <span>Tablebase: ' + tablebase + '</span>';
+        }
+        this.#statusHTML = html;
+        this.#updatePuzzleRun(update.puzzleRun);
+        this.#updateTrainer(update.trainer);
+        this.#updateGuessTheMove(update.guessTheMove);
+        this.#updateDrill(update.drill);
+        this.#updateProblem(update.problem);
+        this.#renderStatus();
+    }
+
+    #updatePuzzleRun(run) {
+        this.#deadline = run ? run.deadline : null;
+        if (this.#deadline && !this.#countdown) {
+            var self = this;
+            this.#countdown = setInterval(() => { self.#renderStatus(); }, 1000);
+        } else if (!this.#deadline && this.#countdown) {
+            clearInterval(this.#countdown);
+            this.#countdown = null;
+        }
+        if (run) {
+            var info = (run.mode == 'rush' ? 'Rush' : 'Streak') + ': ' + run.score;
+            if (run.nickname) {
+                info += ' (best: ' + Math.max(run.best, run.score) + ')';
+            }
+            if (!run.isOver) {
+                info += ' - mate in ' + run.mateIn;
+            }
+            this.#statusHTML = '<h1>' + info + '</h1> - ' + this.#statusHTML;
+        }
+    }
+
+    #updateTrainer(trainer) {
+        if (trainer) {
+            var info = 'Lines: ' + trainer.completed + ' done, ' + trainer.due + '/' + trainer.lines + ' due';
+            if (trainer.mistakes) {
+                info += ', ' + trainer.mistakes + ' mistakes';
+            }
+            this.#statusHTML = '<h1>' + info + '</h1> - ' + this.#statusHTML;
+        }
+    }
+
+    #updateGuessTheMove(guess) {
+        if (guess) {
+            var info = 'Score: ' + guess.score + '/' + guess.maxScore;
+            if (!guess.isOver) {
+                info += ', ' + guess.movesLeft + ' moves left';
+            }
+            this.#statusHTML = '<h1>' + info + '</h1> - ' + this.#statusHTML;
+        }
+    }
+
+    #updateDrill(drill) {
+        if (drill && !drill.result) {
+            var goal = {mate: 'Checkmate', draw: 'Hold the draw', promote: 'Promote'}[drill.goal];
+            var info = drill.title + ': ' + goal + ' (' + (drill.moves - drill.movesPlayed) + ' moves left)';
+            this.#statusHTML = '<h1>' + info + '</h1> - ' + this.#statusHTML;
+        }
+    }
+
+    #updateProblem(problem) {
+        if (problem) {
+            var info = problem.description;
+            if (problem.cooked) {
+                info += ' (cooked: ' + problem.solutions + ' solutions)';
+            }
+            if (problem.isOver) {
+                info += ' - solution: ' + problem.solution.join(', ');
+            }
+            this.#statusHTML = '<h1>' + info + '</h1> - ' + this.#statusHTML;
+        }
+    }
+
+    #renderStatus() {
+        var html = this.#statusHTML;
+        if (this.#deadline) {
+            var secondsLeft = Math.max(0, Math.floor((this.#deadline - Date.now()) / 1000));
+            var seconds = secondsLeft % 60;
+            html = '<h1>' + Math.floor(secondsLeft / 60) + ':' + (seconds < 10 ? '0' : '') + seconds + '</h1> - ' + html;
+        }
         this.#$status.html(html);
     }
 
+    startPuzzleRun(mode) {
+        var nickname = prompt('Nickname for the high score table (leave empty to play anonymously):', localStorage.getItem('nickname') || '');
+        if (nickname === null) return;
+        localStorage.setItem('nickname', nickname);
+        window.location.href = '/puzzle/' + mode + '?nickname=' + encodeURIComponent(nickname);
+    }
+
     updateViewCount(count) {
         this.#$viewers.html(count);
     }
@@ -0,0 +1,276 @@
+// Package board is a small and fast mailbox chess board used for exhaustive searches
+package board
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Color uint8
+
+const (
+	White Color = iota
+	Black
+)
+
+func (c Color) Other() Color {
+	return c ^ 1
+}
+
+func (c Color) String() string {
+	if c == White {
+		return "w"
+	}
+	return "b"
+}
+
+type PieceType uint8
+
+const (
+	NoPieceType PieceType = iota
+	Pawn
+	Knight
+	Bishop
+	Rook
+	Queen
+	King
+)
+
+var pieceChars = " pnbrqk"
+
+type Piece uint8
+
+const NoPiece Piece = 0
+
+func NewPiece(t PieceType, c Color) Piece {
+	return Piece(t) | Piece(c)<<3
+}
+
+func (p Piece) Type() PieceType {
+	return PieceType(p & 7)
+}
+
+func (p Piece) Color() Color {
+	return Color(p >> 3)
+}
+
+// String returns the FEN character of the piece
+func (p Piece) String() string {
+	c := string(pieceChars[p.Type()])
+	if p.Color() == White {
+		return strings.ToUpper(c)
+	}
+	return c
+}
+
+type Square int8
+
+const NoSquare Square = -1
+
+func NewSquare(file, rank int) Square {
+	return Square(rank*8 + file)
+}
+
+func (sq Square) File() int {
+	return int(sq) & 7
+}
+
+func (sq Square) Rank() int {
+	return int(sq) >> 3
+}
+
+func (sq Square) String() string {
+	if sq == NoSquare {
+		return "-"
+	}
+	return string([]byte{byte('a' + sq.File()), byte('1' + sq.Rank())})
+}
+
+func parseSquare(s string) (Square, error) {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return NoSquare, fmt.Errorf("invalid square: %s", s)
+	}
+	return NewSquare(int(s[0]-'a'), int(s[1]-'1')), nil
+}
+
+// castling rights
+const (
+	WhiteKingSide uint8 = 1 << iota
+	WhiteQueenSide
+	BlackKingSide
+	BlackQueenSide
+)
+
+// Board is a chess position without move history
+type Board struct {
+	Squares   [64]Piece
+	Turn      Color
+	Castling  uint8
+	EnPassant Square
+	kings     [2]Square
+}
+
+// New returns an empty board
+func New() *Board {
+	b := &Board{}
+	b.Clear()
+	return b
+}
+
+// Clear removes all pieces and resets the state of the board
+func (b *Board) Clear() {
+	*b = Board{
+		EnPassant: NoSquare,
+		kings:     [2]Square{NoSquare, NoSquare},
+	}
+}
+
+// FromFEN parses a FEN string (the move counters are ignored)
+func FromFEN(fen string) (*Board, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid FEN: %s", fen)
+	}
+	b := New()
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return nil, fmt.Errorf("invalid FEN: %s", fen)
+	}
+	for i, rank := range ranks {
+		file := 0
+		for _, c := range rank {
+			if c >= '1' && c <= '8' {
+				file += int(c - '0')
+				continue
+			}
+			idx := strings.IndexRune(pieceChars, c|0x20)
+			if idx < 1 || file > 7 {
+				return nil, fmt.Errorf("invalid FEN: %s", fen)
+			}
+			color := Black
+			if c < 'a' {
+				color = White
+			}
+			b.Put(NewSquare(file, 7-i), NewPiece(PieceType(idx), color))
+			file++
+		}
+		if file != 8 {
+			return nil, fmt.Errorf("invalid FEN: %s", fen)
+		}
+	}
+	if b.kings[White] == NoSquare || b.kings[Black] == NoSquare {
+		return nil, fmt.Errorf("missing king in FEN: %s", fen)
+	}
+	switch fields[1] {
+	case "w":
+		b.Turn = White
+	case "b":
+		b.Turn = Black
+	default:
+		return nil, fmt.Errorf("invalid FEN: %s", fen)
+	}
+	for _, c := range fields[2] {
+		switch c {
+		case 'K':
+			b.Castling |= WhiteKingSide
+		case 'Q':
+			b.Castling |= WhiteQueenSide
+		case 'k':
+			b.Castling |= BlackKingSide
+		case 'q':
+			b.Castling |= BlackQueenSide
+		}
+	}
+	b.Castling &= b.validCastling()
+	if fields[3] != "-" {
+		ep, err := parseSquare(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		b.EnPassant = ep
+	}
+	return b, nil
+}
+
+// FEN returns the FEN string of the position
+func (b *Board) FEN() string {
+	var sb strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			p := b.Squares[NewSquare(file, rank)]
+			if p == NoPiece {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			sb.WriteString(p.String())
+		}
+		if empty > 0 {
+			sb.WriteString(strconv.Itoa(empty))
+		}
+		if rank > 0 {
+			sb.WriteByte('/')
+		}
+	}
+	castling := ""
+	for i, c := range "KQkq" {
+		if b.Castling&(1<<i) != 0 {
+			castling += string(c)
+		}
+	}
+	if len(castling) == 0 {
+		castling = "-"
+	}
+	return fmt.Sprintf("%s %s %s %s 0 1", sb.String(), b.Turn, castling, b.EnPassant)
+}
+
+// Put places a piece on a square (or removes it if p is NoPiece)
+func (b *Board) Put(sq Square, p Piece) {
+	if old := b.Squares[sq]; old.Type() == King && b.kings[old.Color()] == sq {
+		b.kings[old.Color()] = NoSquare
+	}
+	b.Squares[sq] = p
+	if p.Type() == King {
+		b.kings[p.Color()] = sq
+	}
+}
+
+// KingSquare returns the square of the king of the given color
+func (b *Board) KingSquare(c Color) Square {
+	return b.kings[c]
+}
+
+// PieceCount returns the number of pieces on the board including the kings
+func (b *Board) PieceCount() (count int) {
+	for _, p := range b.Squares {
+		if p != NoPiece {
+			count++
+		}
+	}
+	return
+}
+
+func (b *Board) validCastling() (rights uint8) {
+	if b.Squares[4] == NewPiece(King, White) {
+		if b.Squares[7] == NewPiece(Rook, White) {
+			rights |= WhiteKingSide
+		}
+		if b.Squares[0] == NewPiece(Rook, White) {
+			rights |= WhiteQueenSide
+		}
+	}
+	if b.Squares[60] == NewPiece(King, Black) {
+		if b.Squares[63] == NewPiece(Rook, Black) {
+			rights |= BlackKingSide
+		}
+		if b.Squares[56] == NewPiece(Rook, Black) {
+			rights |= BlackQueenSide
+		}
+	}
+	return
+}
@@ -0,0 +1,389 @@
+package board
+
+// move flags
+const (
+	EnPassantFlag uint8 = 1 << iota
+	CastleFlag
+)
+
+// Move is a move with the information needed to take it back
+type Move struct {
+	From     Square
+	To       Square
+	Promo    PieceType
+	Captured Piece
+	Flags    uint8
+
+	castling  uint8
+	enPassant Square
+}
+
+// String returns the move in UCI notation
+func (m Move) String() string {
+	s := m.From.String() + m.To.String()
+	if m.Promo != NoPieceType {
+		s += string(pieceChars[m.Promo])
+	}
+	return s
+}
+
+var (
+	knightTargets [64][]Square
+	kingTargets   [64][]Square
+	rays          [64][8][]Square // the first 4 directions are orthogonal, the rest are diagonal
+	castlingMask  [64]uint8
+)
+
+func init() {
+	knightOffsets := [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	directions := [][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}, {1, 1}, {-1, 1}, {-1, -1}, {1, -1}}
+	onBoard := func(f, r int) bool {
+		return f >= 0 && f < 8 && r >= 0 && r < 8
+	}
+	for sq := Square(0); sq < 64; sq++ {
+		file, rank := sq.File(), sq.Rank()
+		for _, o := range knightOffsets {
+			if onBoard(file+o[0], rank+o[1]) {
+				knightTargets[sq] = append(knightTargets[sq], NewSquare(file+o[0], rank+o[1]))
+			}
+		}
+		for d, o := range directions {
+			if onBoard(file+o[0], rank+o[1]) {
+				kingTargets[sq] = append(kingTargets[sq], NewSquare(file+o[0], rank+o[1]))
+			}
+			for f, r := file+o[0], rank+o[1]; onBoard(f, r); f, r = f+o[0], r+o[1] {
+				rays[sq][d] = append(rays[sq][d], NewSquare(f, r))
+			}
+		}
+		castlingMask[sq] = 0xf
+	}
+	castlingMask[0] &^= WhiteQueenSide
+	castlingMask[4] &^= WhiteKingSide | WhiteQueenSide
+	castlingMask[7] &^= WhiteKingSide
+	castlingMask[56] &^= BlackQueenSide
+	castlingMask[60] &^= BlackKingSide | BlackQueenSide
+	castlingMask[63] &^= BlackKingSide
+}
+
+func pawnDirection(c Color) Square {
+	if c == White {
+		return 8
+	}
+	return -8
+}
+
+// IsAttacked tells if a square is attacked by any piece of the given color
+func (b *Board) IsAttacked(sq Square, by Color) bool {
+	for _, t := range knightTargets[sq] {
+		if b.Squares[t] == NewPiece(Knight, by) {
+			return true
+		}
+	}
+	for _, t := range kingTargets[sq] {
+		if b.Squares[t] == NewPiece(King, by) {
+			return true
+		}
+	}
+	pawn := NewPiece(Pawn, by)
+	from := sq - pawnDirection(by)
+	if from >= 0 && from < 64 {
+		if sq.File() > 0 && b.Squares[from-1] == pawn {
+			return true
+		}
+		if sq.File() < 7 && b.Squares[from+1] == pawn {
+			return true
+		}
+	}
+	for d := 0; d < 8; d++ {
+		slider := NewPiece(Rook, by)
+		if d >= 4 {
+			slider = NewPiece(Bishop, by)
+		}
+		queen := NewPiece(Queen, by)
+		for _, t := range rays[sq][d] {
+			if p := b.Squares[t]; p != NoPiece {
+				if p == slider || p == queen {
+					return true
+				}
+				break
+			}
+		}
+	}
+	return false
+}
+
+// InCheck tells if the side to move is in check
+func (b *Board) InCheck() bool {
+	return b.IsAttacked(b.kings[b.Turn], b.Turn.Other())
+}
+
+// LegalMoves appends the legal moves of the side to move to the slice
+func (b *Board) LegalMoves(moves []Move) []Move {
+	start := len(moves)
+	moves = b.PseudoLegalMoves(moves)
+	legal := moves[:start]
+	for _, m := range moves[start:] {
+		b.Make(&m)
+		if !b.IsAttacked(b.kings[b.Turn.Other()], b.Turn) {
+			legal = append(legal, m)
+		}
+		b.Unmake(&m)
+	}
+	return legal
+}
+
+// HasLegalMoves tells if the side to move has any legal move
+func (b *Board) HasLegalMoves() bool {
+	var buf [64]Move
+	for _, m := range b.PseudoLegalMoves(buf[:0]) {
+		b.Make(&m)
+		legal := !b.IsAttacked(b.kings[b.Turn.Other()], b.Turn)
+		b.Unmake(&m)
+		if legal {
+			return true
+		}
+	}
+	return false
+}
+
+// PseudoLegalMoves appends the moves of the side to move to the slice without checking if they leave the king in check
+func (b *Board) PseudoLegalMoves(moves []Move) []Move {
+	us := b.Turn
+	for sq := Square(0); sq < 64; sq++ {
+		p := b.Squares[sq]
+		if p == NoPiece || p.Color() != us {
+			continue
+		}
+		switch p.Type() {
+		case Pawn:
+			moves = b.pawnMoves(moves, sq)
+		case Knight:
+			moves = b.targetMoves(moves, sq, knightTargets[sq])
+		case King:
+			moves = b.targetMoves(moves, sq, kingTargets[sq])
+			moves = b.castlingMoves(moves)
+		case Bishop:
+			moves = b.slidingMoves(moves, sq, 4, 8)
+		case Rook:
+			moves = b.slidingMoves(moves, sq, 0, 4)
+		case Queen:
+			moves = b.slidingMoves(moves, sq, 0, 8)
+		}
+	}
+	return moves
+}
+
+func (b *Board) targetMoves(moves []Move, from Square, targets []Square) []Move {
+	for _, to := range targets {
+		if p := b.Squares[to]; p == NoPiece || p.Color() != b.Turn {
+			moves = append(moves, Move{From: from, To: to, Captured: p})
+		}
+	}
+	return moves
+}
+
+func (b *Board) slidingMoves(moves []Move, from Square, firstDir, lastDir int) []Move {
+	for d := firstDir; d < lastDir; d++ {
+		for _, to := range rays[from][d] {
+			p := b.Squares[to]
+			if p == NoPiece {
+				moves = append(moves, Move{From: from, To: to})
+				continue
+			}
+			if p.Color() != b.Turn {
+				moves = append(moves, Move{From: from, To: to, Captured: p})
+			}
+			break
+		}
+	}
+	return moves
+}
+
+func (b *Board) pawnMoves(moves []Move, from Square) []Move {
+	dir := pawnDirection(b.Turn)
+	startRank, lastRank := 1, 7
+	if b.Turn == Black {
+		startRank, lastRank = 6, 0
+	}
+	add := func(to Square, captured Piece, flags uint8) {
+		if to.Rank() == lastRank {
+			for _, promo := range []PieceType{Queen, Rook, Bishop, Knight} {
+				moves = append(moves, Move{From: from, To: to, Promo: promo, Captured: captured, Flags: flags})
+			}
+		} else {
+			moves = append(moves, Move{From: from, To: to, Captured: captured, Flags: flags})
+		}
+	}
+	to := from + dir
+	if b.Squares[to] == NoPiece {
+		add(to, NoPiece, 0)
+		if from.Rank() == startRank && b.Squares[to+dir] == NoPiece {
+			add(to+dir, NoPiece, 0)
+		}
+	}
+	for _, df := range []int{-1, 1} {
+		file := from.File() + df
+		if file < 0 || file > 7 {
+			continue
+		}
+		to := to + Square(df)
+		if p := b.Squares[to]; p != NoPiece && p.Color() != b.Turn {
+			add(to, p, 0)
+		} else if to == b.EnPassant {
+			add(to, NewPiece(Pawn, b.Turn.Other()), EnPassantFlag)
+		}
+	}
+	return moves
+}
+
+func (b *Board) castlingMoves(moves []Move) []Move {
+	us, them := b.Turn, b.Turn.Other()
+	kingSide, queenSide, home := WhiteKingSide, WhiteQueenSide, Square(4)
+	if us == Black {
+		kingSide, queenSide, home = BlackKingSide, BlackQueenSide, 60
+	}
+	if b.Castling&(kingSide|queenSide) == 0 || b.kings[us] != home || b.IsAttacked(home, them) {
+		return moves
+	}
+	if b.Castling&kingSide != 0 && b.Squares[home+1] == NoPiece && b.Squares[home+2] == NoPiece &&
+		!b.IsAttacked(home+1, them) && !b.IsAttacked(home+2, them) {
+		moves = append(moves, Move{From: home, To: home + 2, Flags: CastleFlag})
+	}
+	if b.Castling&queenSide != 0 && b.Squares[home-1] == NoPiece && b.Squares[home-2] == NoPiece && b.Squares[home-3] == NoPiece &&
+		!b.IsAttacked(home-1, them) && !b.IsAttacked(home-2, them) {
+		moves = append(moves, Move{From: home, To: home - 2, Flags: CastleFlag})
+	}
+	return moves
+}
+
+// Make plays a move and saves the state needed by Unmake into it
+func (b *Board) Make(m *Move) {
+	m.castling, m.enPassant = b.Castling, b.EnPassant
+	p := b.Squares[m.From]
+	b.Put(m.From, NoPiece)
+	if m.Flags&EnPassantFlag != 0 {
+		b.Put(m.To-pawnDirection(b.Turn), NoPiece)
+	}
+	if m.Promo != NoPieceType {
+		b.Put(m.To, NewPiece(m.Promo, b.Turn))
+	} else {
+		b.Put(m.To, p)
+	}
+	if m.Flags&CastleFlag != 0 {
+		rookFrom, rookTo := m.From+3, m.From+1
+		if m.To < m.From {
+			rookFrom, rookTo = m.From-4, m.From-1
+		}
+		b.Put(rookTo, b.Squares[rookFrom])
+		b.Put(rookFrom, NoPiece)
+	}
+	b.EnPassant = NoSquare
+	if p.Type() == Pawn && (m.To-m.From == 16 || m.From-m.To == 16) {
+		b.EnPassant = (m.From + m.To) / 2
+	}
+	b.Castling &= castlingMask[m.From] & castlingMask[m.To]
+	b.Turn = b.Turn.Other()
+}
+
+// Unmake takes back a move played by Make
+func (b *Board) Unmake(m *Move) {
+	b.Turn = b.Turn.Other()
+	b.Castling, b.EnPassant = m.castling, m.enPassant
+	p := b.Squares[m.To]
+	if m.Promo != NoPieceType {
+		p = NewPiece(Pawn, b.Turn)
+	}
+	b.Put(m.From, p)
+	if m.Flags&EnPassantFlag != 0 {
+		b.Put(m.To, NoPiece)
+		b.Put(m.To-pawnDirection(b.Turn), m.Captured)
+	} else {
+		b.Put(m.To, m.Captured)
+	}
+	if m.Flags&CastleFlag != 0 {
+		rookFrom, rookTo := m.From+3, m.From+1
+		if m.To < m.From {
+			rookFrom, rookTo = m.From-4, m.From-1
+		}
+		b.Put(rookFrom, b.Squares[rookTo])
+		b.Put(rookTo, NoPiece)
+	}
+}
+
+// RetroMoves appends the quiet moves (no captures, promotions or castling) that the side not to move
+// could have played to reach the position. Calling Unmake with them results in the previous positions
+// (without castling rights and en passant square).
+func (b *Board) RetroMoves(moves []Move) []Move {
+	them := b.Turn.Other()
+	if b.IsAttacked(b.kings[them], b.Turn) {
+		return moves // the side not to move can't be in check
+	}
+	for sq := Square(0); sq < 64; sq++ {
+		p := b.Squares[sq]
+		if p == NoPiece || p.Color() != them {
+			continue
+		}
+		switch p.Type() {
+		case Pawn:
+			if sq.Rank() == 0 || sq.Rank() == 7 {
+				continue
+			}
+			dir := pawnDirection(them)
+			from := sq - dir
+			if b.Squares[from] != NoPiece || from.Rank() == 0 || from.Rank() == 7 {
+				continue
+			}
+			moves = append(moves, Move{From: from, To: sq, castling: b.Castling, enPassant: NoSquare})
+			secondRank := 1
+			if them == Black {
+				secondRank = 6
+			}
+			if from2 := from - dir; from2.Rank() == secondRank && b.Squares[from2] == NoPiece {
+				moves = append(moves, Move{From: from2, To: sq, castling: b.Castling, enPassant: NoSquare})
+			}
+		case Knight:
+			moves = b.retroTargetMoves(moves, sq, knightTargets[sq])
+		case King:
+			moves = b.retroTargetMoves(moves, sq, kingTargets[sq])
+		case Bishop:
+			moves = b.retroSlidingMoves(moves, sq, 4, 8)
+		case Rook:
+			moves = b.retroSlidingMoves(moves, sq, 0, 4)
+		case Queen:
+			moves = b.retroSlidingMoves(moves, sq, 0, 8)
+		}
+	}
+	return moves
+}
+
+func (b *Board) retroTargetMoves(moves []Move, to Square, sources []Square) []Move {
+	for _, from := range sources {
+		if b.Squares[from] == NoPiece {
+			moves = append(moves, Move{From: from, To: to, castling: b.Castling, enPassant: NoSquare})
+		}
+	}
+	return moves
+}
+
+func (b *Board) retroSlidingMoves(moves []Move, to Square, firstDir, lastDir int) []Move {
+	for d := firstDir; d < lastDir; d++ {
+		for _, from := range rays[to][d] {
+			if b.Squares[from] != NoPiece {
+				break
+			}
+			moves = append(moves, Move{From: from, To: to, castling: b.Castling, enPassant: NoSquare})
+		}
+	}
+	return moves
+}
+
+// ParseMove finds the legal move of the side to move in UCI notation
+func (b *Board) ParseMove(uci string) (Move, bool) {
+	var buf [64]Move
+	for _, m := range b.LegalMoves(buf[:0]) {
+		if m.String() == uci {
+			return m, true
+		}
+	}
+	return Move{}, false
+}
@@ -0,0 +1,116 @@
+package board
+
+import (
+	"testing"
+)
+
+func perft(b *Board, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	var buf [256]Move
+	moves := b.LegalMoves(buf[:0])
+	if depth == 1 {
+		return len(moves)
+	}
+	nodes := 0
+	for i := range moves {
+		b.Make(&moves[i])
+		nodes += perft(b, depth-1)
+		b.Unmake(&moves[i])
+	}
+	return nodes
+}
+
+// the node counts are from https://www.chessprogramming.org/Perft_Results
+func TestPerft(t *testing.T) {
+	tests := []struct {
+		fen   string
+		nodes []int // by depth starting from 1
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", []int{20, 400, 8902, 197281}},
+		{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862}},
+		{"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", []int{14, 191, 2812, 43238}},
+		{"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", []int{6, 264, 9467}},
+		{"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", []int{44, 1486, 62379}},
+		{"r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10", []int{46, 2079, 89890}},
+	}
+	for _, test := range tests {
+		b, err := FromFEN(test.fen)
+		if err != nil {
+			t.Fatalf("%s: %v", test.fen, err)
+		}
+		fen := b.FEN()
+		for i, expected := range test.nodes {
+			if nodes := perft(b, i+1); nodes != expected {
+				t.Errorf("%s: perft(%d) = %d, expected %d", test.fen, i+1, nodes, expected)
+			}
+		}
+		if b.FEN() != fen {
+			t.Errorf("the position changed after perft: %s (expected %s)", b.FEN(), fen)
+		}
+	}
+}
+
+func TestFEN(t *testing.T) {
+	fens := []string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 1",
+		"8/8/8/8/8/2k5/8/K1Q5 b - - 0 1",
+	}
+	for _, fen := range fens {
+		b, err := FromFEN(fen)
+		if err != nil {
+			t.Fatalf("%s: %v", fen, err)
+		}
+		if b.FEN() != fen {
+			t.Errorf("FEN() = %s, expected %s", b.FEN(), fen)
+		}
+	}
+	for _, fen := range []string{"", "8/8/8 w - - 0 1", "8/8/8/8/8/8/8/8 w - - 0 1", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - 0 1"} {
+		if _, err := FromFEN(fen); err == nil {
+			t.Errorf("FromFEN accepted an invalid FEN: %q", fen)
+		}
+	}
+}
+
+// TestRetroMoves checks that the quiet moves leading to a position are found by RetroMoves
+// and that taking them back results in the previous position
+func TestRetroMoves(t *testing.T) {
+	fens := []string{
+		"8/8/8/4k3/8/2K5/3P4/8 w - - 0 1",
+		"8/8/3k4/8/8/1N6/2Q5/K7 b - - 0 1",
+		"6k1/5ppp/8/8/8/8/1B3PPP/R5K1 w - - 0 1",
+		"r3k3/1p6/8/8/8/8/6P1/4K2R w - - 0 1",
+	}
+	for _, fen := range fens {
+		b, err := FromFEN(fen)
+		if err != nil {
+			t.Fatalf("%s: %v", fen, err)
+		}
+		var buf [256]Move
+		for _, m := range b.LegalMoves(buf[:0]) {
+			if m.Captured != NoPiece || m.Promo != NoPieceType || m.Flags&CastleFlag != 0 {
+				continue
+			}
+			b.Make(&m)
+			found := false
+			var retroBuf [256]Move
+			for _, r := range b.RetroMoves(retroBuf[:0]) {
+				if r.From != m.From || r.To != m.To {
+					continue
+				}
+				found = true
+				b.Unmake(&r)
+				if b.FEN() != fen {
+					t.Errorf("%s: taking back %s results in %s", fen, r, b.FEN())
+				}
+				b.Make(&r)
+			}
+			if !found {
+				t.Errorf("%s: %s is missing from the retro moves", fen, m)
+			}
+			b.Unmake(&m)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"math/rand"
+
+	"github.com/razzie/blunder/engine"
+	"github.com/razzie/razchess/pkg/board"
+)
+
+var book map[uint64][]engine.PolyglotEntry
+
+// UseBook makes the bots play the moves of a Polyglot opening book while it has any
+func UseBook(filename string) error {
+	entries, err := engine.LoadPolyglotFile(filename)
+	if err != nil {
+		return err
+	}
+	book = entries
+	return nil
+}
+
+// probeBook picks a book move of the position randomly by the weights of the moves
+func probeBook(b *board.Board) (string, bool) {
+	if book == nil {
+		return "", false
+	}
+	var pos engine.Position
+	pos.LoadFEN(b.FEN())
+	entries := book[engine.GenPolyglotHash(&pos)]
+	if len(entries) == 0 {
+		return "", false
+	}
+
+	var candidates []board.Move
+	var weights []int
+	total := 0
+	for _, m := range b.LegalMoves(nil) {
+		for _, e := range entries {
+			if e.Move == polyglotMove(m) && e.Weight > 0 {
+				candidates = append(candidates, m)
+				weights = append(weights, int(e.Weight))
+				total += int(e.Weight)
+				break
+			}
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+	r := rand.Intn(total)
+	for i, w := range weights {
+		if r < w {
+			return candidates[i].String(), true
+		}
+		r -= w
+	}
+	return "", false
+}
+
+// polyglotMove returns the move in the notation of Polyglot books, where castling is the king capturing its rook
+func polyglotMove(m board.Move) string {
+	if m.Flags&board.CastleFlag != 0 {
+		rookFile := 0
+		if m.To.File() == 6 {
+			rookFile = 7
+		}
+		return m.From.String() + board.NewSquare(rookFile, m.To.Rank()).String()
+	}
+	return m.String()
+}
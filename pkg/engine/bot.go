@@ -1,27 +1,22 @@
-package main
+package engine
 
 import (
 	"math"
 
 	"github.com/razzie/blunder/engine"
-	"github.com/razzie/razchess/pkg/razchess"
+	"github.com/razzie/razchess/pkg/board"
 )
 
-func init() {
-	engine.InitBitboards()
-	engine.InitTables()
-	engine.InitZobrist()
-	engine.InitEvalBitboards()
-	engine.InitSearchTables()
-}
-
+// Bot keeps a blunder search in sync with a game to find the best moves
 type Bot struct {
 	search engine.Search
 	setup  bool
 	moves  int
+	board  *board.Board // only used for tablebase and book lookups
 }
 
 func NewBot(moveTime int64, maxDepth uint8) *Bot {
+	initEngine()
 	bot := &Bot{}
 	bot.search.TT.Resize(engine.DefaultTTSize, engine.SearchEntrySize)
 	timeLeft, increment, movesToGo, maxNodeCount := engine.InfiniteTime, engine.NoValue, int16(engine.NoValue), uint64(math.MaxUint64)
@@ -36,18 +31,16 @@ func NewBot(moveTime int64, maxDepth uint8) *Bot {
 	return bot
 }
 
-func (bot *Bot) Update(FEN, PGN string) {
-	startingFEN, moves, err := razchess.ParsePGN(PGN)
-	if err != nil {
-		panic(err)
-	}
-
+// Update plays the moves (in UCI notation) that are new since the last update
+func (bot *Bot) Update(startingFEN string, moves []string) {
 	if !bot.setup {
 		bot.search.Setup(startingFEN)
+		bot.board, _ = board.FromFEN(startingFEN)
 		for _, move := range moves {
 			bot.search.Pos.DoMove(moveFromCoord(&bot.search.Pos, move))
 			bot.search.AddHistory(bot.search.Pos.Hash)
 			bot.search.Pos.StatePly--
+			bot.playOnBoard(move)
 		}
 		bot.moves = len(moves)
 		bot.setup = true
@@ -58,6 +51,7 @@ func (bot *Bot) Update(FEN, PGN string) {
 		bot.search.Pos.DoMove(moveFromCoord(&bot.search.Pos, move))
 		bot.search.AddHistory(bot.search.Pos.Hash)
 		//bot.search.Pos.StatePly--
+		bot.playOnBoard(move)
 		if bot.search.Pos.StatePly == 99 {
 			bot.search.Pos.StatePly = 0
 		}
@@ -66,12 +60,33 @@ func (bot *Bot) Update(FEN, PGN string) {
 }
 
 func (bot *Bot) BestMove() string {
+	if bot.board != nil {
+		if e, ok := probeTablebase(bot.board.FEN()); ok {
+			return e.BestMove
+		}
+		if move, ok := probeBook(bot.board); ok {
+			return move
+		}
+	}
 	return bot.search.Search().String()
 }
 
+func (bot *Bot) playOnBoard(move string) {
+	if bot.board == nil {
+		return
+	}
+	m, ok := bot.board.ParseMove(move)
+	if !ok {
+		bot.board = nil
+		return
+	}
+	bot.board.Make(&m)
+}
+
 func (bot *Bot) Reset() {
 	bot.setup = false
 	bot.moves = 0
+	bot.board = nil
 	bot.search.TT.Clear()
 	bot.search.ClearHistoryTable()
 	bot.search.ClearKillers()
@@ -0,0 +1,89 @@
+// Package engine wraps the blunder chess engine.
+// Note that the vendored blunder is patched to let the searches report their progress to any writer
+// (Search.Output), keep the patch when it's vendored again.
+package engine
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/blunder/engine"
+)
+
+// AnalysisTTSize is the transposition table size (in MB) of the searches used by Analyze
+const AnalysisTTSize = 16
+
+var (
+	initOnce  sync.Once
+	searchers = sync.Pool{
+		New: func() any {
+			search := &engine.Search{Output: io.Discard} // the analyses don't report their progress
+			search.TT.Resize(AnalysisTTSize, engine.SearchEntrySize)
+			return search
+		},
+	}
+)
+
+func initEngine() {
+	initOnce.Do(func() {
+		engine.InitBitboards()
+		engine.InitTables()
+		engine.InitZobrist()
+		engine.InitEvalBitboards()
+		engine.InitSearchTables()
+	})
+}
+
+// Evaluation is the result of a search from the side to move's point of view
+type Evaluation struct {
+	BestMove string `json:"bestMove"` // UCI notation
+	Score    int    `json:"score"`    // centipawns
+	Mate     int    `json:"mate"`     // moves until mate (negative if the side to move gets mated)
+}
+
+// String returns the evaluation in the usual "+1.25" or "#-3" format
+func (e *Evaluation) String() string {
+	if e.Mate != 0 {
+		return fmt.Sprintf("#%d", e.Mate)
+	}
+	return fmt.Sprintf("%+.2f", float64(e.Score)/100)
+}
+
+// Analyze searches the position for the given time (or until the given depth is reached),
+// or probes the tablebase if the position is covered
+func Analyze(fen string, moveTime time.Duration, maxDepth uint8) (*Evaluation, error) {
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	if len(chess.NewGame(opt).ValidMoves()) == 0 {
+		return nil, fmt.Errorf("no legal moves in position: %s", fen)
+	}
+	if e, ok := probeTablebase(fen); ok {
+		return e, nil
+	}
+
+	initEngine()
+	search := searchers.Get().(*engine.Search)
+	defer searchers.Put(search)
+
+	search.Reset()
+	search.Setup(fen)
+	search.Timer.Setup(engine.InfiniteTime, engine.NoValue, moveTime.Milliseconds(), int16(engine.NoValue), maxDepth, math.MaxUint64)
+	e := &Evaluation{BestMove: search.Search().String()}
+	if entry := search.TT.Probe(search.Pos.Hash); entry.Hash == search.Pos.Hash {
+		score := entry.Score
+		switch {
+		case score > engine.Checkmate:
+			e.Mate = int(engine.Inf-score+1) / 2
+		case score < -engine.Checkmate:
+			e.Mate = -int(engine.Inf+score+1) / 2
+		}
+		e.Score = int(score)
+	}
+	return e, nil
+}
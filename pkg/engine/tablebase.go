@@ -0,0 +1,24 @@
+package engine
+
+import (
+	"github.com/razzie/razchess/pkg/tablebase"
+)
+
+var tb *tablebase.Tablebase
+
+// UseTablebase makes Analyze and the bots play perfectly in the positions covered by the tablebase
+func UseTablebase(t *tablebase.Tablebase) {
+	tb = t
+}
+
+// probeTablebase returns the tablebase evaluation of a position if it's available
+func probeTablebase(fen string) (*Evaluation, bool) {
+	if tb == nil {
+		return nil, false
+	}
+	move, result, ok := tb.BestMove(fen)
+	if !ok {
+		return nil, false
+	}
+	return &Evaluation{BestMove: move, Mate: result.MateIn()}, true
+}
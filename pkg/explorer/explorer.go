@@ -0,0 +1,194 @@
+// Package explorer indexes the moves played in a collection of games by position
+// and tells how often each move was played and with what results.
+package explorer
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+)
+
+// MaxPlies is the number of plies indexed from the start of each game
+const MaxPlies = 60
+
+// Stats counts the games and their results
+type Stats struct {
+	Games     int `json:"games"`
+	WhiteWins int `json:"whiteWins"`
+	Draws     int `json:"draws"`
+	BlackWins int `json:"blackWins"`
+}
+
+func (s *Stats) add(outcome chess.Outcome) {
+	s.Games++
+	switch outcome {
+	case chess.WhiteWon:
+		s.WhiteWins++
+	case chess.Draw:
+		s.Draws++
+	case chess.BlackWon:
+		s.BlackWins++
+	}
+}
+
+// MoveStats is a move played in a position and the results of the games it was played in
+type MoveStats struct {
+	Move string `json:"move"` // UCI notation
+	SAN  string `json:"san"`
+	Stats
+}
+
+// Position is the summary of the games that reached a position
+type Position struct {
+	FEN   string       `json:"fen"`
+	Moves []*MoveStats `json:"moves"` // most played first
+	Stats
+}
+
+type index struct {
+	Positions map[string]map[string]*Stats // moves by position key
+	Sources   map[string]bool              // imported files
+}
+
+// Explorer is a position index stored in a file
+type Explorer struct {
+	filename string
+	mtx      sync.RWMutex
+	saveMtx  sync.Mutex
+	index    index
+}
+
+// Open loads the index from filename or starts a new one if the file doesn't exist yet
+func Open(filename string) (*Explorer, error) {
+	e := &Explorer{
+		filename: filename,
+		index: index{
+			Positions: make(map[string]map[string]*Stats),
+			Sources:   make(map[string]bool),
+		},
+	}
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&e.index); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// PositionKey identifies a position by its pieces, side to move and castling rights
+func PositionKey(pos *chess.Position) string {
+	fields := strings.Fields(pos.String())
+	return strings.Join(fields[:3], " ")
+}
+
+// AddGame indexes the first MaxPlies moves of a game
+func (e *Explorer) AddGame(game *chess.Game) {
+	moves := game.Moves()
+	positions := game.Positions()
+	if len(moves) > MaxPlies {
+		moves = moves[:MaxPlies]
+	}
+	outcome := game.Outcome()
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	for i, move := range moves {
+		key := PositionKey(positions[i])
+		stats := e.index.Positions[key]
+		if stats == nil {
+			stats = make(map[string]*Stats)
+			e.index.Positions[key] = stats
+		}
+		uci := chess.UCINotation{}.Encode(positions[i], move)
+		s := stats[uci]
+		if s == nil {
+			s = &Stats{}
+			stats[uci] = s
+		}
+		s.add(outcome)
+	}
+}
+
+// HasSource tells if a source (like an imported file) was already indexed
+func (e *Explorer) HasSource(source string) bool {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.index.Sources[source]
+}
+
+// AddSource marks a source as indexed
+func (e *Explorer) AddSource(source string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.index.Sources[source] = true
+}
+
+// Lookup returns the moves played in a position
+func (e *Explorer) Lookup(fen string) (*Position, error) {
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	pos := chess.NewGame(opt).Position()
+	result := &Position{FEN: pos.String(), Moves: []*MoveStats{}}
+
+	e.mtx.RLock()
+	for uci, s := range e.index.Positions[PositionKey(pos)] {
+		move, err := chess.UCINotation{}.Decode(pos, uci)
+		if err != nil {
+			continue
+		}
+		result.Moves = append(result.Moves, &MoveStats{
+			Move:  uci,
+			SAN:   chess.AlgebraicNotation{}.Encode(pos, move),
+			Stats: *s,
+		})
+		result.Games += s.Games
+		result.WhiteWins += s.WhiteWins
+		result.Draws += s.Draws
+		result.BlackWins += s.BlackWins
+	}
+	e.mtx.RUnlock()
+
+	sort.Slice(result.Moves, func(i, j int) bool {
+		a, b := result.Moves[i], result.Moves[j]
+		if a.Games != b.Games {
+			return a.Games > b.Games
+		}
+		return a.Move < b.Move
+	})
+	return result, nil
+}
+
+// Save writes the index to its file
+func (e *Explorer) Save() error {
+	e.saveMtx.Lock()
+	defer e.saveMtx.Unlock()
+	tmp := e.filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	e.mtx.RLock()
+	err = gob.NewEncoder(f).Encode(&e.index)
+	e.mtx.RUnlock()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.filename)
+}
@@ -0,0 +1,375 @@
+// Package gamedb is an on-disk game database indexed by position, material and pawn structure
+package gamedb
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/explorer"
+)
+
+// MaxResults is the maximum number of games returned by a search
+const MaxResults = 100
+
+// Game is a stored game
+type Game struct {
+	PGN  string
+	Tags map[string]string
+}
+
+// ref is a game and the ply (number of moves played) where it reached an indexed position
+type ref struct {
+	Game int32
+	Ply  int16
+}
+
+type data struct {
+	Games     []*Game
+	Positions map[string][]ref // by pieces, side to move and castling rights
+	Materials map[string][]ref // by material signature like KRPvKR
+	Pawns     map[string][]ref // by pawn placement
+	Sources   map[string]bool  // imported files
+}
+
+// DB is a game database stored in a file
+type DB struct {
+	filename string
+	mtx      sync.RWMutex
+	saveMtx  sync.Mutex
+	data     data
+}
+
+// Open loads the database from filename or starts a new one if the file doesn't exist yet
+func Open(filename string) (*DB, error) {
+	db := &DB{
+		filename: filename,
+		data: data{
+			Positions: make(map[string][]ref),
+			Materials: make(map[string][]ref),
+			Pawns:     make(map[string][]ref),
+			Sources:   make(map[string]bool),
+		},
+	}
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&db.data); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Count returns the number of stored games
+func (db *DB) Count() int {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return len(db.data.Games)
+}
+
+// AddGame stores and indexes a game
+func (db *DB) AddGame(game *chess.Game) {
+	tags := make(map[string]string)
+	for _, tag := range game.TagPairs() {
+		tags[tag.Key] = tag.Value
+	}
+	positions := game.Positions()
+
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	id := int32(len(db.data.Games))
+	db.data.Games = append(db.data.Games, &Game{PGN: strings.TrimSpace(game.String()), Tags: tags})
+	seen := make(map[string]bool) // only the first occurrence of a key in a game is indexed
+	add := func(index map[string][]ref, key string, ply int) {
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		index[key] = append(index[key], ref{Game: id, Ply: int16(ply)})
+	}
+	for ply, pos := range positions {
+		add(db.data.Positions, explorer.PositionKey(pos), ply)
+		add(db.data.Materials, materialKey(pos.Board()), ply)
+		add(db.data.Pawns, pawnKey(pos.Board()), ply)
+	}
+}
+
+// HasSource tells if a source (like an imported file) was already stored
+func (db *DB) HasSource(source string) bool {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	return db.data.Sources[source]
+}
+
+// AddSource marks a source as stored
+func (db *DB) AddSource(source string) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.data.Sources[source] = true
+}
+
+// Game returns a stored game by its ID
+func (db *DB) Game(id int) (*Game, bool) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+	if id < 0 || id >= len(db.data.Games) {
+		return nil, false
+	}
+	return db.data.Games[id], true
+}
+
+// Save writes the database to its file
+func (db *DB) Save() error {
+	db.saveMtx.Lock()
+	defer db.saveMtx.Unlock()
+	tmp := db.filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	db.mtx.RLock()
+	err = gob.NewEncoder(f).Encode(&db.data)
+	db.mtx.RUnlock()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.filename)
+}
+
+// Query is a search in the database, the empty fields are ignored
+type Query struct {
+	FEN      string // exact position
+	Material string // material signature like KRPvKR (white first)
+	Pawns    string // FEN of a position with the same pawn structure
+	Player   string // part of the white or black player's name
+	Event    string // part of the event's name
+	ECO      string // prefix of the ECO code
+	Result   string // 1-0, 0-1 or 1/2-1/2
+}
+
+// IsEmpty tells if the query has no criteria
+func (q *Query) IsEmpty() bool {
+	return *q == Query{}
+}
+
+// Result is a game that matches a query
+type Result struct {
+	ID     int    `json:"id"`
+	Ply    int    `json:"ply"` // the first matching position (0 if the query has no position criteria)
+	White  string `json:"white"`
+	Black  string `json:"black"`
+	Event  string `json:"event"`
+	Date   string `json:"date"`
+	Result string `json:"result"`
+	ECO    string `json:"eco"`
+}
+
+// Search returns the first MaxResults games that match the query and the total number of matching games
+func (db *DB) Search(q Query) ([]*Result, int, error) {
+	type criterion struct {
+		index *map[string][]ref
+		key   string
+	}
+	var criteria []criterion
+	if len(q.FEN) > 0 {
+		pos, err := parseFEN(q.FEN)
+		if err != nil {
+			return nil, 0, err
+		}
+		criteria = append(criteria, criterion{&db.data.Positions, explorer.PositionKey(pos)})
+	}
+	if len(q.Material) > 0 {
+		material, err := parseMaterial(q.Material)
+		if err != nil {
+			return nil, 0, err
+		}
+		criteria = append(criteria, criterion{&db.data.Materials, material})
+	}
+	if len(q.Pawns) > 0 {
+		pos, err := parseFEN(q.Pawns)
+		if err != nil {
+			return nil, 0, err
+		}
+		criteria = append(criteria, criterion{&db.data.Pawns, pawnKey(pos.Board())})
+	}
+
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	// the games matching every position criteria and the ply where the last one was reached
+	var plies map[int32]int
+	for _, c := range criteria {
+		refs := (*c.index)[c.key]
+		matches := make(map[int32]int, len(refs))
+		for _, r := range refs {
+			ply, ok := plies[r.Game]
+			if plies != nil && !ok {
+				continue
+			}
+			if int(r.Ply) > ply {
+				ply = int(r.Ply)
+			}
+			matches[r.Game] = ply
+		}
+		plies = matches
+	}
+
+	var ids []int
+	if plies != nil {
+		ids = make([]int, 0, len(plies))
+		for id := range plies {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+	} else {
+		ids = make([]int, len(db.data.Games))
+		for id := range ids {
+			ids[id] = id
+		}
+	}
+
+	var results []*Result
+	total := 0
+	for _, id := range ids {
+		game := db.data.Games[id]
+		if !q.matchesTags(game.Tags) {
+			continue
+		}
+		total++
+		if len(results) < MaxResults {
+			results = append(results, &Result{
+				ID:     id,
+				Ply:    plies[int32(id)],
+				White:  game.Tags["White"],
+				Black:  game.Tags["Black"],
+				Event:  game.Tags["Event"],
+				Date:   game.Tags["Date"],
+				Result: game.Tags["Result"],
+				ECO:    game.Tags["ECO"],
+			})
+		}
+	}
+	return results, total, nil
+}
+
+func (q *Query) matchesTags(tags map[string]string) bool {
+	contains := func(value, part string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(strings.TrimSpace(part)))
+	}
+	if len(q.Player) > 0 && !contains(tags["White"], q.Player) && !contains(tags["Black"], q.Player) {
+		return false
+	}
+	if len(q.Event) > 0 && !contains(tags["Event"], q.Event) {
+		return false
+	}
+	if len(q.ECO) > 0 && !strings.HasPrefix(strings.ToUpper(tags["ECO"]), strings.ToUpper(strings.TrimSpace(q.ECO))) {
+		return false
+	}
+	if len(q.Result) > 0 && tags["Result"] != strings.TrimSpace(q.Result) {
+		return false
+	}
+	return true
+}
+
+func parseFEN(fen string) (*chess.Position, error) {
+	opt, err := chess.FEN(strings.TrimSpace(fen))
+	if err != nil {
+		return nil, err
+	}
+	return chess.NewGame(opt).Position(), nil
+}
+
+const pieceOrder = "KQRBNP"
+
+// materialKey returns the material signature of a position like KRPvKR
+func materialKey(b *chess.Board) string {
+	var counts [2][6]int
+	for _, p := range b.SquareMap() {
+		side := 0
+		if p.Color() == chess.Black {
+			side = 1
+		}
+		counts[side][pieceIndex(p.Type())]++
+	}
+	var sb strings.Builder
+	for side := 0; side < 2; side++ {
+		if side == 1 {
+			sb.WriteByte('v')
+		}
+		for i, count := range counts[side] {
+			sb.WriteString(strings.Repeat(pieceOrder[i:i+1], count))
+		}
+	}
+	return sb.String()
+}
+
+func pieceIndex(t chess.PieceType) int {
+	switch t {
+	case chess.King:
+		return 0
+	case chess.Queen:
+		return 1
+	case chess.Rook:
+		return 2
+	case chess.Bishop:
+		return 3
+	case chess.Knight:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseMaterial normalizes a material signature like KPRvKR to KRPvKR
+func parseMaterial(s string) (string, error) {
+	sides := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "V")
+	if len(sides) != 2 {
+		return "", fmt.Errorf("invalid material signature: %s (expected something like KRPvKR)", s)
+	}
+	for i, side := range sides {
+		pieces := []byte(side)
+		for _, p := range pieces {
+			if strings.IndexByte(pieceOrder, p) < 0 {
+				return "", fmt.Errorf("invalid piece in material signature: %c", p)
+			}
+		}
+		if strings.Count(side, "K") != 1 {
+			return "", fmt.Errorf("invalid material signature: %s (both sides need exactly one king)", s)
+		}
+		sort.Slice(pieces, func(a, b int) bool {
+			return strings.IndexByte(pieceOrder, pieces[a]) < strings.IndexByte(pieceOrder, pieces[b])
+		})
+		sides[i] = string(pieces)
+	}
+	return sides[0] + "v" + sides[1], nil
+}
+
+// pawnKey returns the placement of the pawns of a position
+func pawnKey(b *chess.Board) string {
+	var sb strings.Builder
+	for sq := chess.A1; sq <= chess.H8; sq++ {
+		switch b.Piece(sq) {
+		case chess.WhitePawn:
+			sb.WriteByte('P')
+		case chess.BlackPawn:
+			sb.WriteByte('p')
+		default:
+			sb.WriteByte('.')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,68 @@
+// Package problem solves composed chess problems: directmates, selfmates and helpmates
+package problem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Kind uint8
+
+const (
+	Directmate Kind = iota // the side to move forces mate
+	Selfmate               // the side to move forces the opponent to give mate
+	Helpmate               // the side to move cooperates with the opponent to get mated
+)
+
+var kindPrefixes = []string{"#", "s#", "h#"}
+
+// MaxMoves is the longest stipulation of each kind the solver accepts
+var MaxMoves = map[Kind]int{
+	Directmate: 4,
+	Selfmate:   3,
+	Helpmate:   3,
+}
+
+// Stipulation is the goal of a problem, like #2, s#3 or h#2
+type Stipulation struct {
+	Kind  Kind
+	Moves int
+}
+
+// ParseStipulation parses a stipulation like #2, s#3 or h#2
+func ParseStipulation(s string) (Stipulation, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for kind := Helpmate; ; kind-- {
+		if prefix := kindPrefixes[kind]; strings.HasPrefix(s, prefix) {
+			moves, err := strconv.Atoi(s[len(prefix):])
+			if err != nil || moves < 1 {
+				break
+			}
+			if moves > MaxMoves[kind] {
+				return Stipulation{}, fmt.Errorf("%s problems are supported up to %d moves", prefix, MaxMoves[kind])
+			}
+			return Stipulation{Kind: kind, Moves: moves}, nil
+		}
+		if kind == Directmate {
+			break
+		}
+	}
+	return Stipulation{}, fmt.Errorf("invalid stipulation: %s (expected #n, s#n or h#n)", s)
+}
+
+func (s Stipulation) String() string {
+	return kindPrefixes[s.Kind] + strconv.Itoa(s.Moves)
+}
+
+// Description returns the stipulation in words
+func (s Stipulation) Description() string {
+	switch s.Kind {
+	case Selfmate:
+		return fmt.Sprintf("Selfmate in %d: force the opponent to give mate", s.Moves)
+	case Helpmate:
+		return fmt.Sprintf("Helpmate in %d: the side to move helps the opponent to mate it", s.Moves)
+	default:
+		return fmt.Sprintf("Mate in %d", s.Moves)
+	}
+}
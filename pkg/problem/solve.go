@@ -0,0 +1,224 @@
+package problem
+
+import (
+	"errors"
+	"time"
+
+	"github.com/razzie/razchess/pkg/board"
+)
+
+// ErrTimeout is returned when a search takes longer than allowed
+var ErrTimeout = errors.New("the problem is too complex to solve in time")
+
+type solver struct {
+	deadline time.Time
+	nodes    int
+	aborted  bool
+}
+
+func newSolver(timeout time.Duration) *solver {
+	return &solver{deadline: time.Now().Add(timeout)}
+}
+
+// tick counts a node and tells if the search has to be aborted
+func (s *solver) tick() bool {
+	s.nodes++
+	if s.nodes&4095 == 0 && time.Now().After(s.deadline) {
+		s.aborted = true
+	}
+	return s.aborted
+}
+
+func (s *solver) err() error {
+	if s.aborted {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// Solve returns every solution of a problem in UCI notation:
+// the key moves of directmates and selfmates or the complete lines of helpmates.
+// More than one solution means the problem is cooked.
+func Solve(b *board.Board, stip Stipulation, timeout time.Duration) ([][]string, error) {
+	s := newSolver(timeout)
+	var solutions [][]string
+	if stip.Kind == Helpmate {
+		s.helpmates(b, stip.Moves, nil, &solutions)
+		return solutions, s.err()
+	}
+	for _, m := range b.LegalMoves(nil) {
+		b.Make(&m)
+		if s.fulfilled(b, stip) {
+			solutions = append(solutions, []string{m.String()})
+		}
+		b.Unmake(&m)
+		if s.aborted {
+			break
+		}
+	}
+	return solutions, s.err()
+}
+
+// Defend finds the best defense in a directmate or selfmate after the move of the attacker.
+// stip.Moves counts the attacker's move that was just played. If the returned defense escapes the stipulation
+// the attacker's move is refuted. ok is false if the defender has no legal moves.
+func Defend(b *board.Board, stip Stipulation, timeout time.Duration) (defense board.Move, refutes bool, ok bool, err error) {
+	s := newSolver(timeout)
+	best := -1
+	for _, d := range b.LegalMoves(nil) {
+		b.Make(&d)
+		length := stip.Moves // remains stip.Moves if the defense escapes
+		if stip.Kind == Selfmate && isMate(b) {
+			length = 0
+		} else {
+			for k := 1; k < stip.Moves; k++ {
+				if s.attacks(b, Stipulation{Kind: stip.Kind, Moves: k}) {
+					length = k
+					break
+				}
+			}
+		}
+		b.Unmake(&d)
+		if s.aborted {
+			return board.Move{}, false, false, ErrTimeout
+		}
+		if length > best {
+			defense, best, ok = d, length, true
+		}
+		if length == stip.Moves {
+			return defense, true, true, nil
+		}
+	}
+	return defense, false, ok, nil
+}
+
+// MainLine returns a line of the solution in UCI notation: for directmates and selfmates the first key move
+// followed by the longest defenses and the attacker's next key moves, for helpmates the first solution
+func MainLine(b *board.Board, stip Stipulation, timeout time.Duration) ([]string, error) {
+	if stip.Kind == Helpmate {
+		solutions, err := Solve(b, stip, timeout)
+		if err != nil || len(solutions) == 0 {
+			return nil, err
+		}
+		return solutions[0], nil
+	}
+	pos := *b
+	var line []string
+	for n := stip.Moves; n > 0; n-- {
+		next := Stipulation{Kind: stip.Kind, Moves: n}
+		keys, err := Solve(&pos, next, timeout)
+		if err != nil || len(keys) == 0 {
+			return line, err
+		}
+		key, _ := pos.ParseMove(keys[0][0])
+		pos.Make(&key)
+		line = append(line, key.String())
+		defense, _, ok, err := Defend(&pos, next, timeout)
+		if err != nil || !ok {
+			return line, err
+		}
+		pos.Make(&defense)
+		line = append(line, defense.String())
+		if stip.Kind == Selfmate && isMate(&pos) {
+			break
+		}
+	}
+	return line, nil
+}
+
+// ShortestMate returns the length of the shortest directmate of the side to move (0 if there is none in maxMoves)
+// and its key moves in UCI notation
+func ShortestMate(b *board.Board, maxMoves int, timeout time.Duration) (int, []string, error) {
+	for n := 1; n <= maxMoves; n++ {
+		solutions, err := Solve(b, Stipulation{Kind: Directmate, Moves: n}, timeout)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(solutions) > 0 {
+			keys := make([]string, len(solutions))
+			for i, solution := range solutions {
+				keys[i] = solution[0]
+			}
+			return n, keys, nil
+		}
+	}
+	return 0, nil, nil
+}
+
+// attacks tells if the attacker (side to move) can fulfill the stipulation
+func (s *solver) attacks(b *board.Board, stip Stipulation) bool {
+	for _, m := range b.LegalMoves(nil) {
+		if stip.Kind == Directmate && stip.Moves == 1 {
+			b.Make(&m)
+			check := b.InCheck()
+			b.Unmake(&m)
+			if !check {
+				continue
+			}
+		}
+		b.Make(&m)
+		ok := s.fulfilled(b, stip)
+		b.Unmake(&m)
+		if ok || s.aborted {
+			return ok
+		}
+	}
+	return false
+}
+
+// fulfilled tells if the stipulation is fulfilled against every defense after the attacker's move,
+// stip.Moves counts the move that lead to the position
+func (s *solver) fulfilled(b *board.Board, stip Stipulation) bool {
+	if s.tick() {
+		return false
+	}
+	var buf [128]board.Move
+	defenses := b.LegalMoves(buf[:0])
+	if len(defenses) == 0 {
+		return stip.Kind == Directmate && b.InCheck()
+	}
+	if stip.Kind == Directmate && stip.Moves <= 1 {
+		return false
+	}
+	next := Stipulation{Kind: stip.Kind, Moves: stip.Moves - 1}
+	for _, d := range defenses {
+		b.Make(&d)
+		ok := (stip.Kind == Selfmate && isMate(b)) || (next.Moves > 0 && s.attacks(b, next))
+		b.Unmake(&d)
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// helpmates collects the lines where the side to move gets mated by the opponent in n moves
+func (s *solver) helpmates(b *board.Board, n int, line []string, solutions *[][]string) {
+	if s.tick() {
+		return
+	}
+	var buf, replyBuf [128]board.Move
+	for _, m := range b.LegalMoves(buf[:0]) {
+		b.Make(&m)
+		for _, r := range b.LegalMoves(replyBuf[:0]) {
+			b.Make(&r)
+			next := append(append(line[:len(line):len(line)], m.String()), r.String())
+			if n == 1 {
+				if isMate(b) {
+					*solutions = append(*solutions, next)
+				}
+			} else if !isMate(b) {
+				s.helpmates(b, n-1, next, solutions)
+			}
+			b.Unmake(&r)
+		}
+		b.Unmake(&m)
+		if s.aborted {
+			return
+		}
+	}
+}
+
+func isMate(b *board.Board) bool {
+	return b.InCheck() && !b.HasLegalMoves()
+}
@@ -0,0 +1,271 @@
+package razchess
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/razchess/internal"
+	"golang.org/x/image/draw"
+)
+
+// animation renders the frames of a game's move history
+type animation struct {
+	opts      GIFOptions
+	moves     []*chess.Move
+	positions []*chess.Position
+	to        int // last ply
+	overlays  *overlayRenderer
+	cache     *boardCache
+	jobs      []frameJob
+}
+
+// frameJob is a frame of a ply: the position itself at step 0 or an interpolated frame of the move leading to it
+type frameJob struct {
+	ply, step int
+}
+
+type renderedFrame struct {
+	frame internal.Frame
+	err   error
+}
+
+func newAnimation(game *chess.Game, opts GIFOptions) (*animation, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	moves, positions := game.Moves(), game.Positions()
+	to := opts.To
+	if to < 0 {
+		to = len(moves)
+	}
+	if to > len(moves) || opts.From > to {
+		return nil, fmt.Errorf("invalid ply range: %d-%d (the game has %d plies)", opts.From, to, len(moves))
+	}
+	if frames := gifFrameCount(len(moves), opts); frames > maxFrames {
+		return nil, fmt.Errorf("too many frames: %d (at most %d are allowed)", frames, maxFrames)
+	}
+	// a theme with missing piece images fails here instead of in the middle of the animation
+	if _, err := getPieceImages(opts.Board.Theme, opts.Board.Size/8); err != nil {
+		return nil, err
+	}
+	a := &animation{
+		opts:      opts,
+		moves:     moves,
+		positions: positions,
+		to:        to,
+		overlays:  newOverlayRenderer(game, opts.Overlays, opts.Board),
+		cache:     newBoardCache(opts.Board),
+	}
+	for ply := opts.From; ply <= to; ply++ {
+		if ply > opts.From {
+			for step := 1; step <= opts.Steps; step++ {
+				a.jobs = append(a.jobs, frameJob{ply, step})
+			}
+		}
+		a.jobs = append(a.jobs, frameJob{ply, 0})
+	}
+	return a, nil
+}
+
+// bounds returns the size of the frames
+func (a *animation) bounds() image.Point {
+	return a.overlays.bounds()
+}
+
+func (a *animation) renderFrame(job frameJob) (*image.RGBA, int, error) {
+	ply := job.ply
+	if job.step > 0 {
+		t := easings[a.opts.Easing](float64(job.step) / float64(a.opts.Steps+1))
+		img, err := renderSlide(a.positions[ply-1], a.moves[ply-1], t, a.opts.Board)
+		if err != nil {
+			return nil, 0, err
+		}
+		// the overlays still show the position before the move
+		return a.overlays.render(ply-1, img), a.opts.StepDelay, nil
+	}
+	var move *chess.Move
+	if ply > 0 {
+		move = a.moves[ply-1]
+	}
+	board, err := a.cache.render(a.positions[ply], move)
+	if err != nil {
+		return nil, 0, err
+	}
+	delay := a.opts.Delay
+	if ply == a.to {
+		delay = a.opts.FinalDelay
+	}
+	return a.overlays.render(ply, board), delay, nil
+}
+
+// frames renders the frames in parallel, but at most a few of them ahead of the consumer, and sends them in order.
+// The frames are converted by the rendering goroutines and the rendering stops when done is closed.
+func (a *animation) frames(convert func(*image.RGBA) image.Image, done <-chan struct{}) (<-chan internal.Frame, <-chan error) {
+	results := make([]chan renderedFrame, len(a.jobs))
+	for i := range results {
+		results[i] = make(chan renderedFrame, 1)
+	}
+	pending := make(chan struct{}, 2*runtime.NumCPU())
+	go func() {
+		for i := range results {
+			select {
+			case pending <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int) {
+				img, delay, err := a.renderFrame(a.jobs[i])
+				if err != nil {
+					results[i] <- renderedFrame{err: err}
+					return
+				}
+				results[i] <- renderedFrame{frame: internal.Frame{Image: convert(img), Delay: delay}}
+			}(i)
+		}
+	}()
+
+	frames := make(chan internal.Frame)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(frames)
+		for _, result := range results {
+			var r renderedFrame
+			select {
+			case r = <-result:
+			case <-done:
+				return
+			}
+			<-pending
+			if r.err != nil {
+				errs <- r.err
+				return
+			}
+			select {
+			case frames <- r.frame:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return frames, errs
+}
+
+// boardCache keeps the board images of the positions already rendered during an export
+type boardCache struct {
+	opts   BoardOptions
+	mtx    sync.Mutex
+	boards map[string]*image.RGBA
+}
+
+func newBoardCache(opts BoardOptions) *boardCache {
+	return &boardCache{opts: opts, boards: make(map[string]*image.RGBA)}
+}
+
+func (c *boardCache) render(pos *chess.Position, move *chess.Move) (*image.RGBA, error) {
+	key := pos.String() // the highlighted move is part of the image too
+	if move != nil {
+		key += " " + move.String()
+	}
+	c.mtx.Lock()
+	board, ok := c.boards[key]
+	c.mtx.Unlock()
+	if ok {
+		return board, nil
+	}
+	board, err := renderPosition(pos, move, c.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.mtx.Lock()
+	c.boards[key] = board
+	c.mtx.Unlock()
+	return board, nil
+}
+
+// easings are the timing functions of the piece movement animation
+var easings = map[string]func(t float64) float64{
+	"linear":  func(t float64) float64 { return t },
+	"ease-in": func(t float64) float64 { return t * t },
+	"ease-out": func(t float64) float64 {
+		return 1 - (1-t)*(1-t)
+	},
+	"ease-in-out": func(t float64) float64 {
+		if t < 0.5 {
+			return 2 * t * t
+		}
+		return 1 - math.Pow(-2*t+2, 2)/2
+	},
+}
+
+func parseEasing(name string) (string, error) {
+	if len(name) == 0 {
+		return "ease-in-out", nil
+	}
+	if _, ok := easings[name]; !ok {
+		return "", fmt.Errorf("invalid easing: %s (expected linear, ease-in, ease-out or ease-in-out)", name)
+	}
+	return name, nil
+}
+
+// renderSlide renders a move in progress: the moving piece (and the rook when castling) is t of the way
+// from its origin to its destination and the captured piece is faded out by t
+func renderSlide(pos *chess.Position, move *chess.Move, t float64, opts BoardOptions) (*image.RGBA, error) {
+	squareSize := opts.Size / 8
+	pieces, err := getPieceImages(opts.Theme, squareSize)
+	if err != nil {
+		return nil, err
+	}
+	board := pos.Board()
+
+	type slide struct {
+		piece    chess.Piece
+		from, to chess.Square
+	}
+	slides := []slide{{board.Piece(move.S1()), move.S1(), move.S2()}}
+	if move.HasTag(chess.KingSideCastle) || move.HasTag(chess.QueenSideCastle) {
+		rank := move.S1().Rank()
+		from, to := chess.NewSquare(chess.FileH, rank), chess.NewSquare(chess.FileF, rank)
+		if move.HasTag(chess.QueenSideCastle) {
+			from, to = chess.NewSquare(chess.FileA, rank), chess.NewSquare(chess.FileD, rank)
+		}
+		slides = append(slides, slide{board.Piece(from), from, to})
+	}
+	captured := chess.NoSquare
+	if move.HasTag(chess.EnPassant) {
+		captured = chess.NewSquare(move.S2().File(), move.S1().Rank())
+	} else if move.HasTag(chess.Capture) {
+		captured = move.S2()
+	}
+
+	// the standing pieces are rendered without the captured one, which is drawn over them fading out
+	squares := board.SquareMap()
+	for _, s := range slides {
+		delete(squares, s.from)
+	}
+	delete(squares, captured)
+	img, err := renderPieces(chess.NewBoard(squares), move, chess.NoSquare, opts)
+	if err != nil {
+		return nil, err
+	}
+	if captured != chess.NoSquare {
+		r := squareRect(captured, squareSize, opts.Inverted)
+		fade := image.NewUniform(color.Alpha{A: uint8(255 * (1 - t))})
+		draw.DrawMask(img, r, pieces[board.Piece(captured)], image.Point{}, fade, image.Point{}, draw.Over)
+	}
+	for _, s := range slides {
+		from := squareRect(s.from, squareSize, opts.Inverted).Min
+		to := squareRect(s.to, squareSize, opts.Inverted).Min
+		offset := image.Point{
+			X: from.X + int(math.Round(float64(to.X-from.X)*t)),
+			Y: from.Y + int(math.Round(float64(to.Y-from.Y)*t)),
+		}
+		r := image.Rect(0, 0, squareSize, squareSize).Add(offset)
+		draw.Draw(img, r, pieces[s.piece], image.Point{}, draw.Over)
+	}
+	return img, nil
+}
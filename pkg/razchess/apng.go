@@ -0,0 +1,30 @@
+package razchess
+
+import (
+	"image"
+	"io"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/razchess/internal"
+)
+
+// MoveHistoryToAPNG renders the positions of a game as an animated PNG, a full color alternative to the GIF
+// with the same options
+func MoveHistoryToAPNG(w io.Writer, game *chess.Game, opts GIFOptions) error {
+	a, err := newAnimation(game, opts)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	frames, errs := a.frames(func(img *image.RGBA) image.Image { return img }, done)
+	if err := internal.EncodeAPNG(w, a.bounds(), frames, len(a.jobs), opts.Loop); err != nil {
+		select {
+		case renderErr := <-errs:
+			return renderErr
+		default:
+			return err
+		}
+	}
+	return nil
+}
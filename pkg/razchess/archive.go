@@ -0,0 +1,408 @@
+package razchess
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+const (
+	archiveDaysKey       = "archive:days"
+	archiveDayKeyPrefix  = "archive:day:"
+	archiveKeyPrefix     = "archive:"
+	archiveDayFormat     = "2006-01-02"
+	maxArchiveResults    = 100
+	maxArchiveGames      = 5000 // kept in memory, the older ones are loaded from the storage when needed
+	maxArchiveSearchDays = 31   // loaded from the storage by a search for older games
+)
+
+// ArchivedGame is a game finished on the server
+type ArchivedGame struct {
+	ID          string            `json:"id"`
+	RoomID      string            `json:"room"`
+	PGN         string            `json:"pgn,omitempty"`
+	Tags        map[string]string `json:"tags"`
+	Result      string            `json:"result"`
+	Termination string            `json:"termination"` // like checkmate or resignation
+	Started     time.Time         `json:"started"`
+	Ended       time.Time         `json:"ended"`
+}
+
+// newArchivedGame returns the archived form of a game, the game is modified so it should be a copy
+func newArchivedGame(roomID string, game *chess.Game, started time.Time) *ArchivedGame {
+	game = withExportTags(game, "", started)
+	game.RemoveTagPair("Site") // the room doesn't outlive the session
+	return &ArchivedGame{
+		RoomID:      roomID,
+		PGN:         strings.TrimSpace(game.String()),
+		Tags:        gameTags(game),
+		Result:      game.Outcome().String(),
+		Termination: terminationText(game),
+		Started:     started.UTC(),
+	}
+}
+
+// summary returns the game without its PGN
+func (g *ArchivedGame) summary() *ArchivedGame {
+	s := *g
+	s.PGN = ""
+	return &s
+}
+
+// day returns the day (YYYY-MM-DD) the game ended on
+func (g *ArchivedGame) day() string {
+	return g.Ended.Format(archiveDayFormat)
+}
+
+// ArchiveQuery is a search in the archive, the empty fields match every game
+type ArchiveQuery struct {
+	Player  string // part of the white or black player's name
+	Result  string // 1-0, 0-1, 1/2-1/2 or * (unfinished)
+	Opening string // prefix of the ECO code or part of the opening's name
+	From    string // first day (YYYY-MM-DD) the game ended on
+	To      string // last day (YYYY-MM-DD) the game ended on
+}
+
+// ArchiveSearch is an archive search and its results (the most recent games first)
+type ArchiveSearch struct {
+	Query   ArchiveQuery    `json:"-"`
+	Results []*ArchivedGame `json:"games"`
+	Total   int             `json:"total"`
+	Partial bool            `json:"partial"` // the older games weren't searched (a shorter date range searches them)
+	Error   string          `json:"-"`
+}
+
+// archive keeps the most recent finished games in memory and every game in the database (if there is one).
+// The database has a record for each game and an index record for each day with the IDs of the games that ended on it.
+type archive struct {
+	mtx     sync.Mutex
+	saveMtx sync.Mutex // keeps the saves in order
+	db      Storage
+	days    []string        // with archived games, in order
+	games   []*ArchivedGame // the most recent ones in the order they ended
+	byID    map[string]*ArchivedGame
+	trimmed bool // there are older games in the database than the ones in memory
+}
+
+func newArchive(db Storage) *archive {
+	a := &archive{
+		db:   db,
+		byID: make(map[string]*ArchivedGame),
+	}
+	if db == nil {
+		return a
+	}
+	a.days = loadArchiveDays(db)
+	var loaded [][]*ArchivedGame // by day, the most recent first
+	count := 0
+	for i := len(a.days) - 1; i >= 0; i-- {
+		if count >= maxArchiveGames {
+			a.trimmed = true
+			break
+		}
+		games := loadArchiveDay(db, a.days[i])
+		loaded = append(loaded, games)
+		count += len(games)
+	}
+	for i := len(loaded) - 1; i >= 0; i-- {
+		for _, game := range loaded[i] {
+			a.remember(game)
+		}
+	}
+	return a
+}
+
+// EachArchivedGame calls fn with the games archived in the storage in the order they ended
+func EachArchivedGame(db Storage, fn func(game *ArchivedGame)) {
+	for _, day := range loadArchiveDays(db) {
+		for _, game := range loadArchiveDay(db, day) {
+			fn(game)
+		}
+	}
+}
+
+// loadArchiveDays returns the days with archived games in order
+func loadArchiveDays(db Storage) []string {
+	days, _ := db.LoadRecord(archiveDaysKey)
+	return strings.Fields(days)
+}
+
+// loadArchiveDay returns the games that ended on a day in the order they ended
+func loadArchiveDay(db Storage, day string) []*ArchivedGame {
+	index, _ := db.LoadRecord(archiveDayKeyPrefix + day)
+	var games []*ArchivedGame
+	for _, id := range strings.Fields(index) {
+		if game, ok := loadArchivedGame(db, id); ok {
+			games = append(games, game)
+		}
+	}
+	return games
+}
+
+func loadArchivedGame(db Storage, id string) (*ArchivedGame, bool) {
+	data, ok := db.LoadRecord(archiveKeyPrefix + id)
+	if !ok {
+		return nil, false
+	}
+	var game ArchivedGame
+	if err := json.Unmarshal([]byte(data), &game); err != nil {
+		log.Println("failed to load archived game:", err)
+		return nil, false
+	}
+	return &game, true
+}
+
+// remember keeps a game in memory and drops the oldest one if there are too many,
+// it's called with the mutex locked (or before the archive is shared)
+func (a *archive) remember(game *ArchivedGame) {
+	a.games = append(a.games, game)
+	a.byID[game.ID] = game
+	if len(a.games) > maxArchiveGames {
+		delete(a.byID, a.games[0].ID)
+		a.games[0] = nil
+		a.games = a.games[1:]
+		a.trimmed = a.db != nil
+	}
+}
+
+// add archives a finished game and returns its archive ID, the game is modified so it should be a copy
+func (a *archive) add(roomID string, game *chess.Game, started time.Time) string {
+	archived := newArchivedGame(roomID, game, started)
+	archived.Ended = time.Now().UTC()
+	day := archived.day()
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for {
+		archived.ID = GenerateID(8)
+		if _, ok := a.byID[archived.ID]; !ok {
+			break
+		}
+	}
+	a.remember(archived)
+	newDay := len(a.days) == 0 || a.days[len(a.days)-1] != day
+	if newDay {
+		a.days = append(a.days, day)
+	}
+	if a.db != nil {
+		go func() {
+			a.save(archived)
+			a.saveIndex(day, archived.ID, newDay)
+		}()
+	}
+	return archived.ID
+}
+
+// update replaces the PGN and the tags of an archived game with the ones of the game (like after its tags were edited),
+// the game is modified so it should be a copy
+func (a *archive) update(id string, game *chess.Game, started time.Time) {
+	updated := newArchivedGame("", game, started)
+	a.mtx.Lock()
+	archived, ok := a.byID[id]
+	if ok {
+		archived.PGN, archived.Tags = updated.PGN, updated.Tags
+	}
+	a.mtx.Unlock()
+	if a.db == nil {
+		return
+	}
+	go func() {
+		if ok {
+			a.save(archived)
+			return
+		}
+		a.saveMtx.Lock()
+		defer a.saveMtx.Unlock()
+		if stored, ok := loadArchivedGame(a.db, id); ok {
+			stored.PGN, stored.Tags = updated.PGN, updated.Tags
+			data, _ := json.Marshal(stored)
+			a.db.SaveRecord(archiveKeyPrefix+id, string(data))
+		}
+	}()
+}
+
+// save saves an archived game as it is at the time of the save, so an earlier save never overwrites a later change
+func (a *archive) save(game *ArchivedGame) {
+	a.saveMtx.Lock()
+	defer a.saveMtx.Unlock()
+	a.mtx.Lock()
+	data, _ := json.Marshal(game)
+	a.mtx.Unlock()
+	a.db.SaveRecord(archiveKeyPrefix+game.ID, string(data))
+}
+
+// saveIndex adds the ID of a game to the index of the day it ended on (and the day to the list of days if it's new)
+func (a *archive) saveIndex(day, id string, newDay bool) {
+	a.saveMtx.Lock()
+	defer a.saveMtx.Unlock()
+	if index, ok := a.db.LoadRecord(archiveDayKeyPrefix + day); ok && len(index) > 0 {
+		id = index + " " + id
+	}
+	a.db.SaveRecord(archiveDayKeyPrefix+day, id)
+	if newDay {
+		a.mtx.Lock()
+		days := strings.Join(a.days, " ")
+		a.mtx.Unlock()
+		a.db.SaveRecord(archiveDaysKey, days)
+	}
+}
+
+// terminationText describes how a game ended like checkmate or threefold repetition (or that it didn't)
+func terminationText(game *chess.Game) string {
+	if game.Outcome() == chess.NoOutcome {
+		return "unfinished"
+	}
+	switch game.Method() {
+	case chess.Checkmate:
+		return "checkmate"
+	case chess.Resignation:
+		return "resignation"
+	}
+	if method, ok := drawMethods[game.Method()]; ok {
+		return method
+	}
+	return ""
+}
+
+// game returns an archived game from memory or the database
+func (a *archive) game(id string) (*ArchivedGame, bool) {
+	a.mtx.Lock()
+	game, ok := a.byID[id]
+	if ok {
+		copied := *game // the tags may be updated later
+		game = &copied
+	}
+	a.mtx.Unlock()
+	if ok || a.db == nil {
+		return game, ok
+	}
+	return loadArchivedGame(a.db, id)
+}
+
+// search searches the games in memory, and the older days of the database if the query has a date range
+// (at most maxArchiveSearchDays of them)
+func (a *archive) search(q ArchiveQuery) *ArchiveSearch {
+	search := &ArchiveSearch{Query: q}
+	from, to, err := q.dateRange()
+	if err != nil {
+		search.Error = err.Error()
+		return search
+	}
+	found := func(game *ArchivedGame) {
+		if !q.matches(game, from, to) {
+			return
+		}
+		search.Total++
+		if len(search.Results) < maxArchiveResults {
+			search.Results = append(search.Results, game.summary())
+		}
+	}
+
+	a.mtx.Lock()
+	for i := len(a.games) - 1; i >= 0; i-- {
+		found(a.games[i])
+	}
+	var olderDays []string // the most recent first
+	var before time.Time   // the end of the oldest game in memory
+	if a.trimmed {
+		// only a date range limits the older days to a few that can be loaded
+		search.Partial = from.IsZero() && to.IsZero()
+		before = a.games[0].Ended
+		for i := len(a.days) - 1; i >= 0 && !search.Partial; i-- {
+			day := a.days[i]
+			if day > before.Format(archiveDayFormat) || (!to.IsZero() && day >= to.Format(archiveDayFormat)) {
+				continue
+			}
+			if !from.IsZero() && day < from.Format(archiveDayFormat) {
+				break
+			}
+			if len(olderDays) == maxArchiveSearchDays {
+				search.Partial = true
+				break
+			}
+			olderDays = append(olderDays, day)
+		}
+	}
+	a.mtx.Unlock()
+
+	for _, day := range olderDays {
+		games := loadArchiveDay(a.db, day)
+		for i := len(games) - 1; i >= 0; i-- {
+			if games[i].Ended.Before(before) {
+				found(games[i])
+			}
+		}
+	}
+	return search
+}
+
+// dateRange returns the time range of the query's days, the zero time means no limit
+func (q *ArchiveQuery) dateRange() (from, to time.Time, err error) {
+	if len(q.From) > 0 {
+		if from, err = time.Parse(archiveDayFormat, strings.TrimSpace(q.From)); err != nil {
+			return from, to, fmt.Errorf("invalid date: %s (expected YYYY-MM-DD)", q.From)
+		}
+	}
+	if len(q.To) > 0 {
+		if to, err = time.Parse(archiveDayFormat, strings.TrimSpace(q.To)); err != nil {
+			return from, to, fmt.Errorf("invalid date: %s (expected YYYY-MM-DD)", q.To)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+func (q *ArchiveQuery) matches(game *ArchivedGame, from, to time.Time) bool {
+	contains := func(value, part string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(strings.TrimSpace(part)))
+	}
+	if len(q.Player) > 0 && !contains(game.Tags["White"], q.Player) && !contains(game.Tags["Black"], q.Player) {
+		return false
+	}
+	if len(q.Result) > 0 && game.Result != strings.TrimSpace(q.Result) {
+		return false
+	}
+	if opening := strings.TrimSpace(q.Opening); len(opening) > 0 &&
+		!strings.HasPrefix(strings.ToUpper(game.Tags["ECO"]), strings.ToUpper(opening)) && !contains(game.Tags["Opening"], opening) {
+		return false
+	}
+	if !from.IsZero() && game.Ended.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !game.Ended.Before(to) {
+		return false
+	}
+	return true
+}
+
+// SearchArchive searches the finished games
+func (mgr *SessionMgr) SearchArchive(q ArchiveQuery) *ArchiveSearch {
+	return mgr.archive.search(q)
+}
+
+// ArchivedGame returns a finished game by its archive ID
+func (mgr *SessionMgr) ArchivedGame(id string) (*ArchivedGame, error) {
+	game, ok := mgr.archive.game(id)
+	if !ok {
+		return nil, fmt.Errorf("archived game not found: %s", id)
+	}
+	return game, nil
+}
+
+// ReopenArchivedGame creates an analysis room with the moves of a finished game
+func (mgr *SessionMgr) ReopenArchivedGame(id string) (string, error) {
+	archived, err := mgr.ArchivedGame(id)
+	if err != nil {
+		return "", err
+	}
+	game, err := replayGame(archived.PGN, -1)
+	if err != nil {
+		return "", err
+	}
+	return mgr.CreateSession(game)
+}
@@ -0,0 +1,172 @@
+package razchess
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// memStorage is a Storage in memory
+type memStorage struct {
+	mtx      sync.Mutex
+	sessions map[string]string
+	records  map[string]string
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{sessions: make(map[string]string), records: make(map[string]string)}
+}
+
+func (s *memStorage) LoadSessions() map[string]string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sessions := make(map[string]string)
+	for room, session := range s.sessions {
+		sessions[room] = session
+	}
+	return sessions
+}
+
+func (s *memStorage) SaveSession(room, session string, expiration time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sessions[room] = session
+}
+
+func (s *memStorage) DeleteSession(room string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.sessions, room)
+}
+
+func (s *memStorage) LoadRecord(key string) (string, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	value, ok := s.records[key]
+	return value, ok
+}
+
+func (s *memStorage) SaveRecord(key, value string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.records[key] = value
+}
+
+// freePlay is a game mode that accepts every legal move
+type freePlay struct{}
+
+func (freePlay) start(sess *Session) {}
+
+func (freePlay) handleMove(sess *Session, move *chess.Move) bool {
+	return sess.game.Move(move) == nil
+}
+
+func (freePlay) handleResign(sess *Session, color chess.Color) {}
+
+func (freePlay) decorate(u *Update) {}
+
+func playMoves(t *testing.T, mgr *SessionMgr, roomID string, moves ...string) {
+	sess, ok := mgr.sessions.Load(roomID)
+	if !ok {
+		t.Fatalf("room not found: %s", roomID)
+	}
+	for _, move := range moves {
+		var valid bool
+		if err := sess.(*Session).Move(move, &valid); err != nil || !valid {
+			t.Fatalf("invalid move: %s", move)
+		}
+	}
+}
+
+// TestArchiveScope checks which games get archived: the finished ones, and the unfinished ones when their room expires
+func TestArchiveScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        bool
+		moves       []string
+		result      string // of the archived game, empty if it isn't archived
+		termination string
+	}{
+		{"checkmate", false, []string{"f2f3", "e7e5", "g2g4", "d8h4"}, "0-1", "checkmate"},
+		{"unfinished", false, []string{"e2e4", "e7e5"}, "*", "unfinished"},
+		{"no moves", false, nil, "", ""},
+		{"game mode", true, []string{"e2e4", "e7e5"}, "", ""},
+	}
+	for _, test := range tests {
+		mgr := NewSessionMgr(nil, time.Hour)
+		var roomID string
+		if test.mode {
+			roomID = mgr.createModeSession(freePlay{})
+		} else {
+			var err error
+			if roomID, err = mgr.CreateSession(""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		playMoves(t, mgr, roomID, test.moves...)
+		mgr.killSession(roomID)
+
+		search := mgr.SearchArchive(ArchiveQuery{})
+		if len(test.result) == 0 {
+			if search.Total != 0 {
+				t.Errorf("%s: %d games archived, expected none", test.name, search.Total)
+			}
+			continue
+		}
+		if search.Total != 1 {
+			t.Errorf("%s: %d games archived, expected 1", test.name, search.Total)
+			continue
+		}
+		game := search.Results[0]
+		if game.RoomID != roomID || game.Result != test.result || game.Termination != test.termination {
+			t.Errorf("%s: archived %s %s (%s), expected %s %s (%s)",
+				test.name, game.RoomID, game.Result, game.Termination, roomID, test.result, test.termination)
+		}
+	}
+}
+
+// TestArchiveTags checks that the tags set after the end of a game are archived too
+func TestArchiveTags(t *testing.T) {
+	mgr := NewSessionMgr(nil, time.Hour)
+	roomID, err := mgr.CreateSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	playMoves(t, mgr, roomID, "f2f3", "e7e5", "g2g4", "d8h4")
+	sess, _ := mgr.sessions.Load(roomID)
+	if err := sess.(*Session).SetTags(map[string]string{"White": "Alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	search := mgr.SearchArchive(ArchiveQuery{Player: "alice"})
+	if search.Total != 1 {
+		t.Fatalf("%d games found, expected 1", search.Total)
+	}
+	game, err := mgr.ArchivedGame(search.Results[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if game.Tags["White"] != "Alice" {
+		t.Errorf("the archived White tag is %q, expected Alice", game.Tags["White"])
+	}
+}
+
+func TestArchivePartialSearch(t *testing.T) {
+	a := newArchive(newMemStorage())
+	a.add("room", chess.NewGame(), time.Now())
+	a.trimmed = true // as if older games were in the database
+	tests := []struct {
+		query   ArchiveQuery
+		partial bool
+	}{
+		{ArchiveQuery{}, true},
+		{ArchiveQuery{Player: "nobody"}, true},
+		{ArchiveQuery{From: time.Now().UTC().Format(archiveDayFormat)}, false},
+	}
+	for _, test := range tests {
+		if search := a.search(test.query); search.Partial != test.partial {
+			t.Errorf("%+v: partial is %v, expected %v", test.query, search.Partial, test.partial)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package razchess
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/board"
+	"github.com/razzie/razchess/pkg/problem"
+)
+
+const (
+	DailyPuzzleDateLayout  = "2006-01-02"
+	DailyPuzzleFeedDays    = 14
+	DailyPuzzleHistoryDays = 365 // how far back the puzzles of past dates are available
+)
+
+// DailyPuzzle is the puzzle of a given UTC date along with its solution
+type DailyPuzzle struct {
+	Date        string   `json:"date"`
+	PuzzleID    int      `json:"puzzleId"`
+	FEN         string   `json:"fen"`
+	Turn        string   `json:"turn"`
+	MateIn      int      `json:"mateIn"`
+	Keys        []string `json:"keys"`
+	Solution    []string `json:"solution"`
+	SolutionUCI []string `json:"solutionUci"`
+}
+
+// Title returns a short description of the puzzle
+func (dp *DailyPuzzle) Title() string {
+	turn := "White"
+	if dp.Turn == chess.Black.String() {
+		turn = "Black"
+	}
+	if dp.MateIn > 0 {
+		return fmt.Sprintf("Daily puzzle %s: %s to move, mate in %d", dp.Date, turn, dp.MateIn)
+	}
+	return fmt.Sprintf("Daily puzzle %s: %s to move", dp.Date, turn)
+}
+
+// DailyPuzzleID deterministically selects a puzzle based on the date and the puzzle set
+func DailyPuzzleID(date time.Time, puzzles []string) int {
+	return dailyPuzzleID(date, puzzleSetHash(puzzles), len(puzzles))
+}
+
+// puzzleSetHash returns the hash of a puzzle set that the daily puzzles are selected by (along with the date)
+func puzzleSetHash(puzzles []string) uint64 {
+	h := fnv.New64a()
+	for _, puzzle := range puzzles {
+		io.WriteString(h, puzzle)
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum64()
+}
+
+func dailyPuzzleID(date time.Time, setHash uint64, count int) int {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], setHash)
+	h.Write(buf[:])
+	io.WriteString(h, date.UTC().Format(DailyPuzzleDateLayout))
+	return int(h.Sum64() % uint64(count))
+}
+
+// ParseDailyPuzzleDate parses a date in YYYY-MM-DD format and makes sure it is neither in the future
+// nor more than DailyPuzzleHistoryDays in the past
+func ParseDailyPuzzleDate(date string) (time.Time, error) {
+	t, err := time.Parse(DailyPuzzleDateLayout, date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t.After(time.Now().UTC()) {
+		return time.Time{}, fmt.Errorf("date is in the future: %s", date)
+	}
+	if t.Before(firstDailyPuzzleDay(time.Now())) {
+		return time.Time{}, fmt.Errorf("date is more than %d days in the past: %s", DailyPuzzleHistoryDays, date)
+	}
+	return t, nil
+}
+
+// firstDailyPuzzleDay returns the earliest day whose puzzle is available
+func firstDailyPuzzleDay(now time.Time) time.Time {
+	return now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -DailyPuzzleHistoryDays)
+}
+
+type dailyPuzzles struct {
+	puzzles   []string
+	setHash   uint64
+	mtx       sync.Mutex
+	cache     map[string]*DailyPuzzle // by day, only the days of the history are kept
+	preparing atomic.Bool
+}
+
+func newDailyPuzzles(puzzles []string) *dailyPuzzles {
+	return &dailyPuzzles{
+		puzzles: puzzles,
+		setHash: puzzleSetHash(puzzles),
+		cache:   make(map[string]*DailyPuzzle),
+	}
+}
+
+// cached returns the puzzle of a day if it was already found
+func (dps *dailyPuzzles) cached(date time.Time) (*DailyPuzzle, bool) {
+	dps.mtx.Lock()
+	defer dps.mtx.Unlock()
+	dp, ok := dps.cache[date.UTC().Format(DailyPuzzleDateLayout)]
+	return dp, ok
+}
+
+// prepare finds the puzzles of the last days in the background (unless it's already doing so)
+func (dps *dailyPuzzles) prepare(now time.Time, days int) {
+	if !dps.preparing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer dps.preparing.Store(false)
+		today := now.UTC().Truncate(24 * time.Hour)
+		for i := 0; i < days; i++ {
+			if _, err := dps.get(today.AddDate(0, 0, -i)); err != nil {
+				log.Println("failed to find the daily puzzle:", err)
+			}
+		}
+	}()
+}
+
+func (dps *dailyPuzzles) get(date time.Time) (*DailyPuzzle, error) {
+	if dp, ok := dps.cached(date); ok {
+		return dp, nil
+	}
+	day := date.UTC().Format(DailyPuzzleDateLayout)
+	puzzleID := dailyPuzzleID(date, dps.setHash, len(dps.puzzles))
+	opts, err := parseGame(dps.puzzles[puzzleID])
+	if err != nil {
+		return nil, err
+	}
+	pos := chess.NewGame(opts...).Position()
+	b, err := board.FromFEN(pos.String())
+	if err != nil {
+		return nil, err
+	}
+	mateIn, keys, err := problem.ShortestMate(b, maxPuzzleMoves, puzzleSolveTime)
+	if err != nil {
+		return nil, err
+	}
+	dp := &DailyPuzzle{
+		Date:        day,
+		PuzzleID:    puzzleID,
+		FEN:         pos.String(),
+		Turn:        pos.Turn().String(),
+		MateIn:      mateIn,
+		Keys:        []string{},
+		Solution:    []string{},
+		SolutionUCI: []string{},
+	}
+	dp.Keys = append(dp.Keys, keys...)
+	if mateIn > 0 {
+		line, err := problem.MainLine(b, problem.Stipulation{Kind: problem.Directmate, Moves: mateIn}, puzzleSolveTime)
+		if err != nil {
+			return nil, err
+		}
+		for _, uci := range line {
+			move := findValidMove(pos, uci)
+			dp.Solution = append(dp.Solution, chess.AlgebraicNotation{}.Encode(pos, move))
+			dp.SolutionUCI = append(dp.SolutionUCI, uci)
+			pos = pos.Update(move)
+		}
+	}
+	dps.store(day, dp)
+	return dp, nil
+}
+
+// store caches a puzzle and drops the ones that fell out of the history
+func (dps *dailyPuzzles) store(day string, dp *DailyPuzzle) {
+	first := firstDailyPuzzleDay(time.Now()).Format(DailyPuzzleDateLayout)
+	dps.mtx.Lock()
+	defer dps.mtx.Unlock()
+	for d := range dps.cache {
+		if d < first {
+			delete(dps.cache, d)
+		}
+	}
+	if day >= first {
+		dps.cache[day] = dp
+	}
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Content atomContent `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// writeFeed writes an Atom feed of the daily puzzles of the last days, baseURL is like https://host.
+// Only the puzzles already found are listed, the missing ones are searched in the background for the next time.
+func (dps *dailyPuzzles) writeFeed(w io.Writer, baseURL string, now time.Time, days int) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+	feed := atomFeed{
+		Title:   "RazChess daily puzzles",
+		ID:      baseURL + "/puzzle/daily.atom",
+		Updated: today.Format(time.RFC3339),
+		Author:  "RazChess",
+		Links: []atomLink{
+			{Href: baseURL + "/puzzle/daily.atom", Rel: "self", Type: "application/atom+xml"},
+			{Href: baseURL + "/puzzle/daily", Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for i := 0; i < days; i++ {
+		date := today.AddDate(0, 0, -i)
+		dp, ok := dps.cached(date)
+		if !ok {
+			dps.prepare(now, days)
+			continue
+		}
+		pageURL := baseURL + "/puzzle/daily/" + dp.Date
+		imageURL := pageURL + ".png"
+		var content strings.Builder
+		fmt.Fprintf(&content, `<p><a href="%s"><img src="%s" alt="%s" /></a></p>`, pageURL, imageURL, dp.FEN)
+		fmt.Fprintf(&content, `<p>%s</p>`, dp.Title())
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   dp.Title(),
+			ID:      pageURL,
+			Updated: date.Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: pageURL, Rel: "alternate", Type: "text/html"},
+				{Href: imageURL, Rel: "enclosure", Type: "image/png"},
+			},
+			Content: atomContent{Type: "html", Body: content.String()},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(feed)
+}
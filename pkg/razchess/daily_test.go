@@ -0,0 +1,91 @@
+package razchess
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDailyPuzzleID(t *testing.T) {
+	puzzles := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	day := time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		date time.Time
+		same bool // as the puzzle of day
+	}{
+		{"same day", day, true},
+		{"later on the same day", day.Add(23 * time.Hour), true},
+		{"same moment in another time zone", day.In(time.FixedZone("UTC-5", -5*3600)), true},
+	}
+	id := DailyPuzzleID(day, puzzles)
+	if id < 0 || id >= len(puzzles) {
+		t.Fatalf("puzzle ID out of range: %d", id)
+	}
+	for _, test := range tests {
+		if other := DailyPuzzleID(test.date, puzzles); (other == id) != test.same {
+			t.Errorf("%s: puzzle %d, the puzzle of %s is %d", test.name, other, day.Format(DailyPuzzleDateLayout), id)
+		}
+	}
+
+	// the puzzles change from day to day and with the puzzle set
+	ids := make(map[int]bool)
+	changed := false
+	reordered := append([]string{puzzles[len(puzzles)-1]}, puzzles[:len(puzzles)-1]...)
+	for i := 0; i < 60; i++ {
+		date := day.AddDate(0, 0, i)
+		ids[DailyPuzzleID(date, puzzles)] = true
+		if DailyPuzzleID(date, puzzles) != DailyPuzzleID(date, reordered) {
+			changed = true
+		}
+		dps := newDailyPuzzles(puzzles)
+		if got := dailyPuzzleID(date, dps.setHash, len(dps.puzzles)); got != DailyPuzzleID(date, puzzles) {
+			t.Errorf("%s: the cached set hash selects puzzle %d instead of %d", date.Format(DailyPuzzleDateLayout), got, DailyPuzzleID(date, puzzles))
+		}
+	}
+	if len(ids) < len(puzzles)/2 {
+		t.Errorf("only %d different puzzles in 60 days", len(ids))
+	}
+	if !changed {
+		t.Errorf("reordering the puzzle set didn't change any daily puzzle")
+	}
+}
+
+func TestParseDailyPuzzleDate(t *testing.T) {
+	today := time.Now().UTC()
+	tests := []struct {
+		date  string
+		valid bool
+	}{
+		{today.Format(DailyPuzzleDateLayout), true},
+		{today.AddDate(0, 0, -DailyPuzzleHistoryDays+1).Format(DailyPuzzleDateLayout), true},
+		{today.AddDate(0, 0, -DailyPuzzleHistoryDays-1).Format(DailyPuzzleDateLayout), false},
+		{today.AddDate(0, 0, 2).Format(DailyPuzzleDateLayout), false},
+		{"0001-01-01", false},
+		{"2026-13-01", false},
+		{"yesterday", false},
+	}
+	for _, test := range tests {
+		if _, err := ParseDailyPuzzleDate(test.date); (err == nil) != test.valid {
+			t.Errorf("%s: error %v, expected valid = %v", test.date, err, test.valid)
+		}
+	}
+}
+
+// TestDailyPuzzleFeed checks that the feed lists the puzzles already found without waiting for the others
+func TestDailyPuzzleFeed(t *testing.T) {
+	dps := newDailyPuzzles(GetInternalPuzzles())
+	dps.preparing.Store(true) // no searches in the background
+	now := time.Now()
+	for _, days := range []int{0, 2} {
+		date := now.AddDate(0, 0, -days)
+		dps.store(date.UTC().Format(DailyPuzzleDateLayout), &DailyPuzzle{Date: date.UTC().Format(DailyPuzzleDateLayout), FEN: "8/8/8/8/8/8/8/8 w - - 0 1"})
+	}
+	var sb strings.Builder
+	if err := dps.writeFeed(&sb, "https://example.com", now, DailyPuzzleFeedDays); err != nil {
+		t.Fatal(err)
+	}
+	if entries := strings.Count(sb.String(), "<entry>"); entries != 2 {
+		t.Errorf("the feed has %d entries, expected 2", entries)
+	}
+}
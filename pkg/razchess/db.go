@@ -3,11 +3,16 @@ package razchess
 import (
 	"context"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// keys of non-session records start with this prefix, room IDs never contain ':'
+const recordKeyPrefix = "razchess:"
+
+// DB is a Storage in Redis
 type DB redis.Client
 
 func NewDB(redisURL string) (*DB, error) {
@@ -30,6 +35,9 @@ func (db *DB) LoadSessions() map[string]string {
 	}
 	results := make(map[string]string)
 	for _, room := range rooms {
+		if strings.HasPrefix(room, recordKeyPrefix) {
+			continue
+		}
 		game, err := db.Get(context.Background(), room).Result()
 		if err != nil {
 			log.Println("Redis error:", err)
@@ -45,3 +53,26 @@ func (db *DB) SaveSession(room, game string, expiration time.Duration) {
 		log.Println("Redis error:", err)
 	}
 }
+
+func (db *DB) DeleteSession(room string) {
+	if err := db.Del(context.Background(), room).Err(); err != nil {
+		log.Println("Redis error:", err)
+	}
+}
+
+func (db *DB) LoadRecord(key string) (string, bool) {
+	value, err := db.Get(context.Background(), recordKeyPrefix+key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Println("Redis error:", err)
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (db *DB) SaveRecord(key, value string) {
+	if err := db.Set(context.Background(), recordKeyPrefix+key, value, 0).Err(); err != nil {
+		log.Println("Redis error:", err)
+	}
+}
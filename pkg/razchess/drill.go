@@ -0,0 +1,230 @@
+package razchess
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/engine"
+	"github.com/razzie/razchess/pkg/tablebase"
+)
+
+const (
+	DrillMate     = "mate"
+	DrillDraw     = "draw"
+	DrillPromote  = "promote"
+	MaxDrillMoves = 100
+	drillMoveTime = 500 * time.Millisecond
+	drillMaxDepth = 30
+)
+
+// DrillSetup describes an endgame drill
+type DrillSetup struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	FEN   string `json:"fen"`
+	Goal  string `json:"goal"`
+	Moves int    `json:"moves"`
+}
+
+var drills = map[string]*DrillSetup{
+	"kqk":      {Name: "kqk", Title: "Queen vs king (KQvK)", FEN: "8/8/8/4k3/8/8/8/4K2Q w - - 0 1", Goal: DrillMate, Moves: 15},
+	"krk":      {Name: "krk", Title: "Rook vs king (KRvK)", FEN: "8/8/8/4k3/8/8/8/R3K3 w - - 0 1", Goal: DrillMate, Moves: 25},
+	"kbnk":     {Name: "kbnk", Title: "Bishop and knight vs king (KBNvK)", FEN: "8/8/8/4k3/8/8/8/2B1KN2 w - - 0 1", Goal: DrillMate, Moves: 40},
+	"lucena":   {Name: "lucena", Title: "Lucena position", FEN: "1K1k4/1P6/8/8/8/8/r7/2R5 w - - 0 1", Goal: DrillPromote, Moves: 20},
+	"philidor": {Name: "philidor", Title: "Philidor position", FEN: "4k3/8/7r/4PK2/8/8/8/R7 b - - 0 1", Goal: DrillDraw, Moves: 25},
+}
+
+var drawMethods = map[chess.Method]string{
+	chess.Stalemate:            "stalemate",
+	chess.InsufficientMaterial: "insufficient material",
+	chess.ThreefoldRepetition:  "threefold repetition",
+	chess.FivefoldRepetition:   "fivefold repetition",
+	chess.FiftyMoveRule:        "the fifty-move rule",
+	chess.SeventyFiveMoveRule:  "the seventy-five-move rule",
+	chess.DrawOffer:            "agreement",
+}
+
+// Drills returns the built-in endgame drills
+func Drills() []*DrillSetup {
+	list := make([]*DrillSetup, 0, len(drills))
+	for _, setup := range drills {
+		list = append(list, setup)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Drill is the state of an endgame drill
+type Drill struct {
+	DrillSetup
+	Color       string `json:"color"`
+	MovesPlayed int    `json:"movesPlayed"`
+	Result      string `json:"result,omitempty"` // "success" or "failed"
+	Message     string `json:"message,omitempty"`
+}
+
+type drill struct {
+	state Drill
+	color chess.Color
+}
+
+func newDrill(setup DrillSetup) (*drill, error) {
+	switch setup.Goal {
+	case DrillMate, DrillDraw, DrillPromote:
+	default:
+		return nil, fmt.Errorf("unknown drill goal: %s", setup.Goal)
+	}
+	if setup.Moves < 1 || setup.Moves > MaxDrillMoves {
+		return nil, fmt.Errorf("the number of moves should be between 1 and %d", MaxDrillMoves)
+	}
+	opt, err := chess.FEN(setup.FEN)
+	if err != nil {
+		return nil, err
+	}
+	pos := chess.NewGame(opt).Position()
+	if pos.Status() != chess.NoMethod {
+		return nil, fmt.Errorf("the drill position is already over")
+	}
+	if endgameTables != nil {
+		if result, ok := endgameTables.Probe(setup.FEN); ok {
+			if err := checkDrillSetup(&setup, result); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(setup.Title) == 0 {
+		setup.Title = "Custom drill"
+	}
+	return &drill{
+		state: Drill{
+			DrillSetup: setup,
+			Color:      pos.Turn().Name(),
+		},
+		color: pos.Turn(),
+	}, nil
+}
+
+func (d *drill) start(sess *Session) {
+	sess.game = newGameFromFEN(d.state.FEN)
+}
+
+func (d *drill) handleMove(sess *Session, move *chess.Move) bool {
+	if len(d.state.Result) > 0 || sess.game.Position().Turn() != d.color || !sess.handleMove(move) {
+		return false
+	}
+	d.state.MovesPlayed++
+	if d.checkGoal(sess.game, move) || d.checkTablebase(sess.game.Position().String()) {
+		sess.updateClients()
+		return true
+	}
+	sess.updateClients()
+
+	e, err := engine.Analyze(sess.game.Position().String(), drillMoveTime, drillMaxDepth)
+	if err != nil || !sess.handleMoveStr(e.BestMove) {
+		d.finish(false, "The engine failed to move")
+		sess.updateClients()
+		return true
+	}
+	d.checkGoal(sess.game, nil)
+	sess.updateClients()
+	return true
+}
+
+func (d *drill) handleResign(sess *Session, color chess.Color) {
+	if len(d.state.Result) == 0 && color == d.color {
+		d.finish(false, "Resigned")
+	}
+}
+
+func (d *drill) decorate(u *Update) {
+	state := d.state
+	u.Drill = &state
+	u.Orientation = strings.ToLower(state.Color)
+	u.Tablebase = nil // no hints during the drill
+	if len(state.Result) > 0 {
+		u.IsGameOver = true
+		u.Status = state.Message
+	}
+}
+
+// checkGoal checks the drill goal after a move (the trainee's move if not nil) and tells if the drill is over
+func (d *drill) checkGoal(game *chess.Game, move *chess.Move) bool {
+	pos := game.Position()
+	movesLeft := d.state.Moves - d.state.MovesPlayed
+	switch {
+	case pos.Status() == chess.Checkmate && pos.Turn() != d.color:
+		d.finish(true, "Checkmate")
+	case pos.Status() == chess.Checkmate:
+		d.finish(false, "You got checkmated")
+	case game.Outcome() == chess.Draw:
+		d.finish(d.state.Goal == DrillDraw, "Draw by "+drawMethods[game.Method()])
+	case d.state.Goal == DrillPromote && move != nil && move.Promo() != chess.NoPieceType:
+		d.finish(true, "Promoted")
+	case movesLeft > 0:
+	case d.state.Goal == DrillDraw:
+		if move == nil {
+			d.finish(true, fmt.Sprintf("Held the draw for %d moves", d.state.Moves))
+		}
+	default:
+		d.finish(false, fmt.Sprintf("Failed to %s within %d moves", d.state.Goal, d.state.Moves))
+	}
+	return len(d.state.Result) > 0
+}
+
+// checkTablebase fails the drill early if the tablebase tells that the goal can't be reached anymore
+func (d *drill) checkTablebase(fen string) bool {
+	if endgameTables == nil {
+		return false
+	}
+	result, ok := endgameTables.Probe(fen) // from the engine's point of view
+	if !ok {
+		return false
+	}
+	movesLeft := d.state.Moves - d.state.MovesPlayed
+	switch d.state.Goal {
+	case DrillMate:
+		if result.WDL != -1 {
+			d.finish(false, "The position is not winning anymore")
+		} else if mateIn := -result.MateIn(); mateIn > movesLeft {
+			d.finish(false, fmt.Sprintf("The mate takes %d more moves with best play", mateIn))
+		}
+	case DrillDraw, DrillPromote:
+		if result.WDL == 1 {
+			d.finish(false, "The position is lost")
+		}
+	}
+	return len(d.state.Result) > 0
+}
+
+// checkDrillSetup tells if the goal of the drill is reachable according to the tablebase
+func checkDrillSetup(setup *DrillSetup, result *tablebase.Result) error {
+	switch setup.Goal {
+	case DrillMate:
+		if result.WDL != 1 {
+			return fmt.Errorf("the position is not winning (tablebase: %s)", result)
+		}
+		if result.MateIn() > setup.Moves {
+			return fmt.Errorf("the fastest mate takes %d moves", result.MateIn())
+		}
+	case DrillDraw, DrillPromote:
+		if result.WDL == -1 {
+			return fmt.Errorf("the position is lost (tablebase: %s)", result)
+		}
+	}
+	return nil
+}
+
+func (d *drill) finish(success bool, message string) {
+	if success {
+		d.state.Result = "success"
+		d.state.Message = "Drill completed: " + message
+	} else {
+		d.state.Result = "failed"
+		d.state.Message = "Drill failed: " + message
+	}
+}
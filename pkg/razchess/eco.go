@@ -0,0 +1,86 @@
+package razchess
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/opening"
+	"github.com/razzie/razchess/pkg/explorer"
+)
+
+var (
+	book          opening.Book = opening.NewBookECO()
+	ecoIndexOnce  sync.Once
+	ecoByPosition map[string]*opening.Opening
+)
+
+// buildECOIndex indexes the openings by their final positions, so they are found after transpositions too
+func buildECOIndex() {
+	ecoByPosition = make(map[string]*opening.Opening)
+	positions := map[string]*chess.Position{"": chess.NewGame().Position()} // by move sequence
+	for _, o := range book.Possible(nil) {
+		moves := strings.Fields(o.PGN()) // the ECO data contains the moves in UCI notation
+		pos := positions[""]
+		for i := range moves {
+			line := strings.Join(moves[:i+1], " ")
+			next, ok := positions[line]
+			if !ok {
+				move, err := chess.UCINotation{}.Decode(pos, moves[i])
+				if err != nil {
+					break
+				}
+				next = pos.Update(move)
+				positions[line] = next
+			}
+			pos = next
+		}
+		key := explorer.PositionKey(pos)
+		if prev, ok := ecoByPosition[key]; !ok || isPreferredOpening(o, prev) {
+			ecoByPosition[key] = o
+		}
+	}
+}
+
+// isPreferredOpening tells if an opening should be named instead of another one reaching the same position
+func isPreferredOpening(o, other *opening.Opening) bool {
+	if len(o.PGN()) != len(other.PGN()) {
+		return len(o.PGN()) < len(other.PGN())
+	}
+	if o.Code() != other.Code() {
+		return o.Code() < other.Code()
+	}
+	return o.Title() < other.Title()
+}
+
+// findOpening returns the opening of the latest position of the game that is in the ECO book
+func findOpening(game *chess.Game) *opening.Opening {
+	openings := openingsByPly(game.Positions())
+	return openings[len(openings)-1]
+}
+
+// openingsByPly returns the opening of the latest position in the ECO book up to each position
+func openingsByPly(positions []*chess.Position) []*opening.Opening {
+	ecoIndexOnce.Do(buildECOIndex)
+	openings := make([]*opening.Opening, len(positions))
+	var current *opening.Opening
+	for i, pos := range positions {
+		if o, ok := ecoByPosition[explorer.PositionKey(pos)]; ok {
+			current = o
+		}
+		openings[i] = current
+	}
+	return openings
+}
+
+// withOpeningTags returns a copy of the game with ECO and Opening tags if it has none yet
+func withOpeningTags(game *chess.Game, o *opening.Opening) *chess.Game {
+	if o == nil || game.GetTagPair("ECO") != nil {
+		return game
+	}
+	game = game.Clone()
+	game.RemoveTagPair("Opening") // AddTagPair would overwrite the tag shared with the original game
+	game.AddTagPair("ECO", o.Code())
+	game.AddTagPair("Opening", o.Title())
+	return game
+}
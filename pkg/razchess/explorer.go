@@ -0,0 +1,130 @@
+package razchess
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/explorer"
+)
+
+// explorerSaveDelay is how long the games finished on the server are collected before the index is saved,
+// so the index isn't rewritten after every game (the games of the last delay are lost if the server is killed)
+const explorerSaveDelay = time.Minute
+
+var (
+	exp              *explorer.Explorer
+	expSaveMtx       sync.Mutex
+	expSaveScheduled bool
+)
+
+// EnableExplorer enables the opening explorer with its index stored in filename
+// and indexes the PGN files that weren't imported yet
+func EnableExplorer(filename string, pgnFiles []string) error {
+	e, err := explorer.Open(filename)
+	if err != nil {
+		return err
+	}
+	imported := 0
+	for _, pgnFile := range pgnFiles {
+		count, err := importExplorerPGN(e, pgnFile)
+		if err != nil {
+			log.Println("explorer:", err)
+			continue
+		}
+		imported += count
+	}
+	if imported > 0 {
+		if err := e.Save(); err != nil {
+			return err
+		}
+	}
+	exp = e
+	return nil
+}
+
+// importExplorerPGN indexes the games of a PGN file unless the same version of the file was imported before
+func importExplorerPGN(e *explorer.Explorer, filename string) (int, error) {
+	source, err := pgnSource(filename)
+	if err != nil {
+		return 0, err
+	}
+	if e.HasSource(source) {
+		return 0, nil
+	}
+	count, err := readPGNFile(filename, e.AddGame)
+	if err != nil {
+		return 0, err
+	}
+	e.AddSource(source)
+	log.Printf("explorer: imported %d games from %s", count, filename)
+	return count, nil
+}
+
+// pgnSource identifies the current version of a PGN file, so it's only imported once
+func pgnSource(filename string) (string, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().Unix()), nil
+}
+
+// readPGNFile calls fn with every game of a PGN file that can be parsed and returns their count
+func readPGNFile(filename string, fn func(*chess.Game)) (int, error) {
+	pgn, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, game := range SplitPGN(string(pgn)) {
+		opt, err := chess.PGN(strings.NewReader(game))
+		if err != nil {
+			continue
+		}
+		fn(chess.NewGame(opt))
+		count++
+	}
+	return count, nil
+}
+
+// exploreGame adds a game finished on the server to the explorer
+func exploreGame(game *chess.Game) {
+	if exp == nil {
+		return
+	}
+	exp.AddGame(game)
+	expSaveMtx.Lock()
+	defer expSaveMtx.Unlock()
+	if !expSaveScheduled {
+		expSaveScheduled = true
+		time.AfterFunc(explorerSaveDelay, saveExplorer)
+	}
+}
+
+// saveExplorer saves the index, the games added during the save schedule the next one
+func saveExplorer() {
+	expSaveMtx.Lock()
+	expSaveScheduled = false
+	expSaveMtx.Unlock()
+	if err := exp.Save(); err != nil {
+		log.Println("explorer:", err)
+	}
+}
+
+// ExplorePosition returns the moves played in a position according to the explorer
+func ExplorePosition(fen string) (*explorer.Position, error) {
+	if exp == nil {
+		return nil, fmt.Errorf("the explorer is not enabled")
+	}
+	return exp.Lookup(fen)
+}
@@ -0,0 +1,139 @@
+package razchess
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// ExportFormat is a game export format with its content type and file extension
+type ExportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+// ExportFormats are the supported game export formats by name
+var ExportFormats = map[string]ExportFormat{
+	"pgn":  {"application/x-chess-pgn", "pgn"},
+	"epd":  {"text/plain; charset=utf-8", "epd"},
+	"json": {"application/json", "json"},
+	"uci":  {"text/plain; charset=utf-8", "txt"},
+}
+
+// ExportedMove is a ply of the JSON export
+type ExportedMove struct {
+	Ply int    `json:"ply"`
+	SAN string `json:"san"`
+	UCI string `json:"uci"`
+	FEN string `json:"fen"` // after the move
+}
+
+// ExportedGame is the JSON export of a game
+type ExportedGame struct {
+	Tags        map[string]string `json:"tags"`
+	StartingFEN string            `json:"startingFen"`
+	Moves       []ExportedMove    `json:"moves"`
+	Result      string            `json:"result"`
+}
+
+// seven tag roster and the other tags filled by the export, in the order they are written
+var exportTagOrder = []string{"Event", "Site", "Date", "Round", "White", "Black", "Result", "ECO", "Opening", "TimeControl", "Termination"}
+
+// withExportTags returns the game with the standard tags filled from the session data (keeping the existing ones)
+// in the usual order. The game is modified, so it should be a copy.
+func withExportTags(game *chess.Game, site string, created time.Time) *chess.Game {
+	tags := make(map[string]string)
+	existing := game.TagPairs()
+	for _, tag := range existing {
+		tags[tag.Key] = tag.Value
+	}
+	setDefault := func(key, value string) {
+		if len(strings.Trim(tags[key], "?.")) == 0 { // missing or unknown like ? and ????.??.??
+			tags[key] = value
+		}
+	}
+	setDefault("Event", "RazChess game")
+	setDefault("Site", site)
+	setDefault("Date", created.Format("2006.01.02"))
+	setDefault("Round", "-")
+	setDefault("White", "?")
+	setDefault("Black", "?")
+	tags["Result"] = game.Outcome().String()
+	if o := findOpening(game); o != nil {
+		setDefault("ECO", o.Code())
+		setDefault("Opening", o.Title())
+	}
+	setDefault("TimeControl", "-") // there are no clocks
+	if game.Outcome() == chess.NoOutcome {
+		tags["Termination"] = "unterminated"
+	} else {
+		setDefault("Termination", "normal")
+	}
+
+	for _, tag := range existing {
+		game.RemoveTagPair(tag.Key)
+	}
+	for _, key := range exportTagOrder {
+		if value, ok := tags[key]; ok {
+			game.AddTagPair(key, value)
+			delete(tags, key)
+		}
+	}
+	for _, tag := range existing {
+		if value, ok := tags[tag.Key]; ok {
+			game.AddTagPair(tag.Key, value)
+		}
+	}
+	return game
+}
+
+// exportGame writes a game in one of the ExportFormats
+func exportGame(w io.Writer, game *chess.Game, format string) error {
+	moves, positions := game.Moves(), game.Positions()
+	switch format {
+	case "pgn":
+		_, err := io.WriteString(w, strings.TrimSpace(game.String())+"\n")
+		return err
+
+	case "epd":
+		for ply, pos := range positions {
+			fields := strings.Fields(pos.String())
+			if _, err := fmt.Fprintf(w, "%s hmvc %s; fmvn %s; id \"ply %d\";\n", strings.Join(fields[:4], " "), fields[4], fields[5], ply); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "json":
+		exported := ExportedGame{
+			Tags:        gameTags(game),
+			StartingFEN: positions[0].String(),
+			Moves:       make([]ExportedMove, len(moves)),
+			Result:      game.Outcome().String(),
+		}
+		for i, move := range moves {
+			exported.Moves[i] = ExportedMove{
+				Ply: i + 1,
+				SAN: chess.AlgebraicNotation{}.Encode(positions[i], move),
+				UCI: chess.UCINotation{}.Encode(positions[i], move),
+				FEN: positions[i+1].String(),
+			}
+		}
+		return json.NewEncoder(w).Encode(exported)
+
+	case "uci":
+		uci := make([]string, len(moves))
+		for i, move := range moves {
+			uci[i] = chess.UCINotation{}.Encode(positions[i], move)
+		}
+		_, err := io.WriteString(w, strings.Join(uci, " ")+"\n")
+		return err
+
+	default:
+		return fmt.Errorf("invalid export format: %s", format)
+	}
+}
@@ -0,0 +1,89 @@
+package razchess
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+func newExportTestGame(t *testing.T) *chess.Game {
+	game := chess.NewGame()
+	for _, move := range []string{"e2e4", "e7e5"} {
+		if err := game.Move(findValidMove(game.Position(), move)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	game.AddTagPair("White", "Alice")
+	game.AddTagPair("Annotator", "Bob")
+	created := time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)
+	return withExportTags(game, "https://example.com/room/abc", created)
+}
+
+func TestExportGame(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		// the double space before the result comes from notnil/chess
+		{"pgn", `[Event "RazChess game"]
+[Site "https://example.com/room/abc"]
+[Date "2024.05.17"]
+[Round "-"]
+[White "Alice"]
+[Black "?"]
+[Result "*"]
+[ECO "C20"]
+[Opening "King's Pawn Game"]
+[TimeControl "-"]
+[Termination "unterminated"]
+[Annotator "Bob"]
+
+1. e4 e5  *
+`},
+		{"epd", `rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - hmvc 0; fmvn 1; id "ply 0";
+rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 hmvc 0; fmvn 1; id "ply 1";
+rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 hmvc 0; fmvn 2; id "ply 2";
+`},
+		{"uci", "e2e4 e7e5\n"},
+	}
+	game := newExportTestGame(t)
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := exportGame(&buf, game, test.format); err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%s: got\n%s\nexpected\n%s", test.format, buf.String(), test.expected)
+		}
+	}
+}
+
+func TestExportGameJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportGame(&buf, newExportTestGame(t), "json"); err != nil {
+		t.Fatal(err)
+	}
+	var exported ExportedGame
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Tags["White"] != "Alice" || exported.Result != "*" || len(exported.Moves) != 2 {
+		t.Fatalf("unexpected export: %s", buf.String())
+	}
+	expected := ExportedMove{Ply: 2, SAN: "e5", UCI: "e7e5", FEN: "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2"}
+	if exported.Moves[1] != expected {
+		t.Errorf("the second move is %+v, expected %+v", exported.Moves[1], expected)
+	}
+}
+
+func TestExportGameInvalidFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportGame(&buf, newExportTestGame(t), "doc"); err == nil || !strings.Contains(err.Error(), "doc") {
+		t.Errorf("got %v, expected an invalid format error", err)
+	}
+}
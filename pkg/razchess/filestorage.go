@@ -0,0 +1,121 @@
+package razchess
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStorage is a Storage in a local directory, with a file for each session and record
+// (named by the hex encoded room ID or key, so any ID or key is a valid file name on every system)
+type FileStorage struct {
+	mtx sync.Mutex
+	dir string
+}
+
+// storedSession is the content of a session file
+type storedSession struct {
+	Session string    `json:"session"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewFileStorage opens (and creates if needed) a file storage in dir
+func NewFileStorage(dir string) (*FileStorage, error) {
+	for _, sub := range []string{"sessions", "records"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+func (s *FileStorage) sessionPath(room string) string {
+	return filepath.Join(s.dir, "sessions", hex.EncodeToString([]byte(room))+".json")
+}
+
+func (s *FileStorage) recordPath(key string) string {
+	return filepath.Join(s.dir, "records", hex.EncodeToString([]byte(key)))
+}
+
+func (s *FileStorage) LoadSessions() map[string]string {
+	files, err := os.ReadDir(filepath.Join(s.dir, "sessions"))
+	if err != nil {
+		log.Println("Storage error:", err)
+		return nil
+	}
+	now := time.Now()
+	results := make(map[string]string)
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		room, err := hex.DecodeString(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(s.sessionPath(string(room)))
+		if err != nil {
+			log.Println("Storage error:", err)
+			continue
+		}
+		var sess storedSession
+		if err := json.Unmarshal(data, &sess); err != nil {
+			log.Println("Storage error:", err)
+			continue
+		}
+		if now.After(sess.Expires) {
+			s.DeleteSession(string(room))
+			continue
+		}
+		results[string(room)] = sess.Session
+	}
+	return results
+}
+
+func (s *FileStorage) SaveSession(room, session string, expiration time.Duration) {
+	data, _ := json.Marshal(storedSession{Session: session, Expires: time.Now().Add(expiration)})
+	s.write(s.sessionPath(room), data)
+}
+
+func (s *FileStorage) DeleteSession(room string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := os.Remove(s.sessionPath(room)); err != nil && !os.IsNotExist(err) {
+		log.Println("Storage error:", err)
+	}
+}
+
+func (s *FileStorage) LoadRecord(key string) (string, bool) {
+	data, err := os.ReadFile(s.recordPath(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Storage error:", err)
+		}
+		return "", false
+	}
+	return string(data), true
+}
+
+func (s *FileStorage) SaveRecord(key, value string) {
+	s.write(s.recordPath(key), []byte(value))
+}
+
+// write replaces a file through a temporary one, so a crash never leaves it half written
+func (s *FileStorage) write(path string, data []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Println("Storage error:", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Println("Storage error:", err)
+	}
+}
@@ -0,0 +1,67 @@
+package razchess
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStorageSessions(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := map[string]string{
+		"abc":          "fen:rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"../../passwd": "pgn:1. e4 *", // any room ID is a valid file name
+		"room/with\\":  "pgn:1. d4 *",
+	}
+	for room, session := range sessions {
+		s.SaveSession(room, session, time.Hour)
+	}
+	s.SaveSession("expired", "pgn:1. c4 *", -time.Second)
+	s.SaveSession("deleted", "pgn:1. Nf3 *", time.Hour)
+	s.DeleteSession("deleted")
+	s.DeleteSession("missing")
+
+	// reopened like after a restart
+	if s, err = NewFileStorage(dir); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := s.LoadSessions(); !reflect.DeepEqual(loaded, sessions) {
+		t.Errorf("loaded %q, expected %q", loaded, sessions)
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, "sessions"))
+	if len(files) != len(sessions) {
+		t.Errorf("%d session files, expected %d (the expired one is deleted when loaded)", len(files), len(sessions))
+	}
+}
+
+func TestFileStorageRecords(t *testing.T) {
+	s, err := NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key, value string
+	}{
+		{"highscores:streak", `{"alice":12}`},
+		{"repertoire:a/b", "progress"},
+		{"empty", ""},
+	}
+	for _, test := range tests {
+		s.SaveRecord(test.key, "old")
+		s.SaveRecord(test.key, test.value)
+	}
+	for _, test := range tests {
+		if value, ok := s.LoadRecord(test.key); !ok || value != test.value {
+			t.Errorf("%s: loaded %q (%v), expected %q", test.key, value, ok, test.value)
+		}
+	}
+	if _, ok := s.LoadRecord("missing"); ok {
+		t.Error("a missing record was found")
+	}
+}
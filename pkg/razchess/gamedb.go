@@ -0,0 +1,128 @@
+package razchess
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/gamedb"
+)
+
+var gdb *gamedb.DB
+
+// EnableGameDB enables the game database stored in filename and imports the PGN files that weren't imported yet
+func EnableGameDB(filename string, pgnFiles []string) error {
+	db, err := gamedb.Open(filename)
+	if err != nil {
+		return err
+	}
+	imported := 0
+	for _, pgnFile := range pgnFiles {
+		count, err := importGameDBPGN(db, pgnFile)
+		if err != nil {
+			log.Println("gamedb:", err)
+			continue
+		}
+		imported += count
+	}
+	if imported > 0 {
+		if err := db.Save(); err != nil {
+			return err
+		}
+	}
+	gdb = db
+	return nil
+}
+
+// importGameDBPGN stores the games of a PGN file (with their openings if they aren't tagged yet)
+// unless the same version of the file was imported before
+func importGameDBPGN(db *gamedb.DB, filename string) (int, error) {
+	source, err := pgnSource(filename)
+	if err != nil {
+		return 0, err
+	}
+	if db.HasSource(source) {
+		return 0, nil
+	}
+	count, err := readPGNFile(filename, func(game *chess.Game) {
+		db.AddGame(withOpeningTags(game, findOpening(game)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	db.AddSource(source)
+	log.Printf("gamedb: imported %d games from %s", count, filename)
+	return count, nil
+}
+
+// GameDBSearch is a game database search and its results
+type GameDBSearch struct {
+	Query   gamedb.Query
+	Results []*gamedb.Result
+	Total   int
+	Games   int
+	Error   string
+}
+
+// SearchGameDB searches the game database
+func SearchGameDB(q gamedb.Query) *GameDBSearch {
+	if gdb == nil {
+		return &GameDBSearch{Query: q, Error: "the game database is not enabled"}
+	}
+	search := &GameDBSearch{Query: q, Games: gdb.Count()}
+	if q.IsEmpty() {
+		return search
+	}
+	results, total, err := gdb.Search(q)
+	if err != nil {
+		search.Error = err.Error()
+		return search
+	}
+	search.Results, search.Total = results, total
+	return search
+}
+
+// gameDBGameAt returns a stored game until the given ply in the format of sessions
+func gameDBGameAt(id, ply int) (string, error) {
+	if gdb == nil {
+		return "", fmt.Errorf("the game database is not enabled")
+	}
+	stored, ok := gdb.Game(id)
+	if !ok {
+		return "", fmt.Errorf("game not found")
+	}
+	if ply < 0 {
+		return "", fmt.Errorf("invalid ply: %d", ply)
+	}
+	return replayGame(stored.PGN, ply)
+}
+
+// replayGame returns a game until the given ply (or all of it if ply is negative) without its result,
+// so it can be continued, in the format of sessions
+func replayGame(pgn string, ply int) (string, error) {
+	opt, err := chess.PGN(strings.NewReader(pgn))
+	if err != nil {
+		return "", err
+	}
+	original := chess.NewGame(opt)
+	moves := original.Moves()
+	if ply < 0 {
+		ply = len(moves)
+	}
+	if ply > len(moves) {
+		return "", fmt.Errorf("invalid ply: %d", ply)
+	}
+	game := newGameFromFEN(original.Positions()[0].String())
+	for _, tag := range original.TagPairs() {
+		if tag.Key != "Result" && game.GetTagPair(tag.Key) == nil {
+			game.AddTagPair(tag.Key, tag.Value)
+		}
+	}
+	for _, move := range moves[:ply] {
+		if err := game.Move(findValidMove(game.Position(), move.String())); err != nil {
+			return "", err
+		}
+	}
+	return gameToString(game), nil
+}
@@ -0,0 +1,181 @@
+package razchess
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+const maxGameSetGames = 500
+
+// ErrGameSetNotFound is returned for unknown or expired game set IDs
+var ErrGameSetNotFound = errors.New("game set not found")
+
+// GameSet is a set of games uploaded together (like the games of a tournament), each of them opens in its own room
+type GameSet struct {
+	ID    string
+	Games []*GameSetEntry
+}
+
+// GameSetEntry is a game of a set with its main tags
+type GameSetEntry struct {
+	Number  int // starting from 1
+	White   string
+	Black   string
+	Result  string
+	Event   string
+	Round   string
+	Date    string
+	ECO     string
+	Opening string
+	Plies   int
+	pgn     string
+}
+
+// GameSetLink is the place of a room in its game set
+type GameSetLink struct {
+	SetID  string
+	Number int
+	Count  int
+}
+
+type gameSet struct {
+	GameSet
+	mtx       sync.Mutex
+	rooms     []string // room IDs of the games opened so far
+	killTimer *time.Timer
+}
+
+// newGameSet parses the games of a PGN database, skipping the invalid ones
+func newGameSet(pgns []string) (*gameSet, error) {
+	if len(pgns) > maxGameSetGames {
+		return nil, fmt.Errorf("too many games: %d (at most %d are allowed)", len(pgns), maxGameSetGames)
+	}
+	set := &gameSet{}
+	for _, pgn := range pgns {
+		opt, err := chess.PGN(strings.NewReader(pgn))
+		if err != nil {
+			continue
+		}
+		game := chess.NewGame(opt)
+		entry := &GameSetEntry{
+			Number: len(set.Games) + 1,
+			White:  getTagValue(game, "White"),
+			Black:  getTagValue(game, "Black"),
+			Result: game.Outcome().String(),
+			Event:  getTagValue(game, "Event"),
+			Round:  getTagValue(game, "Round"),
+			Date:   getTagValue(game, "Date"),
+			ECO:    getTagValue(game, "ECO"),
+			Plies:  len(game.Moves()),
+			pgn:    pgn,
+		}
+		if o := findOpening(game); o != nil && (len(entry.ECO) == 0 || entry.ECO == o.Code()) {
+			entry.ECO, entry.Opening = o.Code(), o.Title()
+		}
+		set.Games = append(set.Games, entry)
+	}
+	if len(set.Games) == 0 {
+		return nil, fmt.Errorf("no valid games found")
+	}
+	set.rooms = make([]string, len(set.Games))
+	return set, nil
+}
+
+// CreateGameSet keeps the games of a PGN database as a set (in memory only, like the rooms of the game modes)
+// and returns its ID
+func (mgr *SessionMgr) CreateGameSet(pgns []string) (string, error) {
+	set, err := newGameSet(pgns)
+	if err != nil {
+		return "", err
+	}
+	for {
+		// the timer has to exist before the set is stored, getGameSet resets it
+		id := GenerateID(6)
+		set.ID = id
+		set.killTimer = time.AfterFunc(mgr.killTimeout, func() {
+			log.Printf("[game set expired: %s]", id)
+			mgr.sets.Delete(id)
+		})
+		if _, loaded := mgr.sets.LoadOrStore(id, set); !loaded {
+			break
+		}
+		set.killTimer.Stop()
+	}
+	log.Printf("[new game set: %s] %d games", set.ID, len(set.Games))
+	return set.ID, nil
+}
+
+func (mgr *SessionMgr) getGameSet(setID string) (*gameSet, error) {
+	set, ok := mgr.sets.Load(setID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrGameSetNotFound, setID)
+	}
+	set.(*gameSet).killTimer.Reset(mgr.killTimeout)
+	return set.(*gameSet), nil
+}
+
+// GameSet returns the games of a set
+func (mgr *SessionMgr) GameSet(setID string) (*GameSet, error) {
+	set, err := mgr.getGameSet(setID)
+	if err != nil {
+		return nil, err
+	}
+	return &set.GameSet, nil
+}
+
+// OpenGameSetGame returns the room of a game in a set (by its number), the room is created when the game is
+// opened for the first time or after its previous room expired
+func (mgr *SessionMgr) OpenGameSetGame(setID string, number int) (string, error) {
+	set, err := mgr.getGameSet(setID)
+	if err != nil {
+		return "", err
+	}
+	if number < 1 || number > len(set.Games) {
+		return "", fmt.Errorf("invalid game number: %d (the set has %d games)", number, len(set.Games))
+	}
+	set.mtx.Lock()
+	defer set.mtx.Unlock()
+	if roomID := set.rooms[number-1]; len(roomID) > 0 {
+		if _, ok := mgr.sessions.Load(roomID); ok {
+			return roomID, nil
+		}
+	}
+	roomID, err := mgr.CreateSession("pgn:" + set.Games[number-1].pgn)
+	if err != nil {
+		return "", err
+	}
+	set.rooms[number-1] = roomID
+	mgr.setRooms.Store(roomID, GameSetLink{SetID: setID, Number: number, Count: len(set.Games)})
+	return roomID, nil
+}
+
+// GameSetOf returns the game set of a room opened from a set (if the set still exists)
+func (mgr *SessionMgr) GameSetOf(roomID string) (GameSetLink, bool) {
+	link, ok := mgr.setRooms.Load(roomID)
+	if !ok {
+		return GameSetLink{}, false
+	}
+	if _, ok := mgr.sets.Load(link.(GameSetLink).SetID); !ok {
+		return GameSetLink{}, false
+	}
+	return link.(GameSetLink), true
+}
+
+// Prev returns the number of the previous game in the set or 0 at the first game
+func (l GameSetLink) Prev() int {
+	return l.Number - 1
+}
+
+// Next returns the number of the next game in the set or 0 at the last game
+func (l GameSetLink) Next() int {
+	if l.Number >= l.Count {
+		return 0
+	}
+	return l.Number + 1
+}
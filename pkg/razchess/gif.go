@@ -1,108 +1,217 @@
 package razchess
 
 import (
+	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
 	"io"
+	"net/url"
+	"sort"
 
 	"github.com/notnil/chess"
-	"github.com/razzie/chessimage"
 	"github.com/razzie/razchess/pkg/razchess/internal"
 )
 
-const boardSize = 512
+const (
+	maxFrameDelay = 1000 // centiseconds
+	maxFinalDelay = 3000
+	maxLoopCount  = 100
+	maxSteps      = 30
+	maxFrames     = 5000
 
-var palette = getPalette()
+	maxPieceColors = 8 // in the GIF palettes
+)
 
-func MoveHistoryToGIF(w io.Writer, moves []*chess.Move, positions []*chess.Position) error {
-	renderers := make([]*chessimage.Renderer, 0, len(positions))
-	initialPos := positions[0]
-	positions = positions[1:]
+// GIFOptions are the options of the animated move history
+type GIFOptions struct {
+	Board      BoardOptions
+	Delay      int // centiseconds per frame
+	FinalDelay int // centiseconds to hold the last frame
+	Loop       int // number of times the animation is played (0 means forever)
+	From       int // first ply
+	To         int // last ply (-1 means the end of the game)
+	Overlays   Overlays
+	Steps      int    // interpolated frames sliding the pieces of each move (0 means no animation)
+	StepDelay  int    // centiseconds per interpolated frame
+	Easing     string // timing function of the animation (linear, ease-in, ease-out or ease-in-out)
+}
 
-	img, err := prepareMoveRenderer(initialPos, nil)
-	if err != nil {
-		return err
+// DefaultGIFOptions returns the options used when none are given
+func DefaultGIFOptions() GIFOptions {
+	return GIFOptions{
+		Board:      DefaultBoardOptions(),
+		Delay:      100,
+		FinalDelay: 100,
+		To:         -1,
+		StepDelay:  3,
+		Easing:     "ease-in-out",
 	}
-	renderers = append(renderers, img)
+}
 
-	for i, move := range moves {
-		img, err := prepareMoveRenderer(positions[i], move)
-		if err != nil {
-			return err
-		}
-		renderers = append(renderers, img)
-	}
-
-	images := make(chan *image.Paletted)
-	go func() {
-		for _, r := range renderers {
-			img, _ := r.Render(chessimage.Options{
-				PieceRatio: 1,
-				BoardSize:  boardSize,
-			})
-			bounds := img.Bounds()
-			palettedImage := image.NewPaletted(bounds, palette)
-			draw.Draw(palettedImage, bounds, img, image.Point{}, draw.Over)
-			images <- palettedImage
-		}
-		close(images)
-	}()
+// ParseGIFOptions reads the GIF options from URL query parameters: the board options (see ParseBoardOptions),
+// delay and hold (centiseconds), loop, from and to (plies), overlays (see parseOverlays),
+// animate (interpolated frames per move), step (centiseconds per interpolated frame) and easing
+func ParseGIFOptions(query url.Values) (GIFOptions, error) {
+	opts := DefaultGIFOptions()
+	board, err := ParseBoardOptions(query)
+	if err != nil {
+		return opts, err
+	}
+	opts.Board = board
+	if err := parseIntParams(query, map[string]*int{
+		"delay":   &opts.Delay,
+		"hold":    &opts.FinalDelay,
+		"loop":    &opts.Loop,
+		"from":    &opts.From,
+		"to":      &opts.To,
+		"animate": &opts.Steps,
+		"step":    &opts.StepDelay,
+	}); err != nil {
+		return opts, err
+	}
+	if opts.Easing, err = parseEasing(query.Get("easing")); err != nil {
+		return opts, err
+	}
+	if opts.Overlays, err = parseOverlays(query.Get("overlays")); err != nil {
+		return opts, err
+	}
+	if len(query.Get("hold")) == 0 {
+		opts.FinalDelay = opts.Delay
+	}
+	return opts, opts.validate()
+}
 
-	return convertImagesToGif(w, images, 100)
+func (o *GIFOptions) validate() error {
+	if err := o.Board.validate(); err != nil {
+		return err
+	}
+	if o.Delay < 1 || o.Delay > maxFrameDelay {
+		return fmt.Errorf("delay must be between 1 and %d centiseconds", maxFrameDelay)
+	}
+	if o.FinalDelay < 0 || o.FinalDelay > maxFinalDelay {
+		return fmt.Errorf("hold must be between 0 and %d centiseconds", maxFinalDelay)
+	}
+	if o.Loop < 0 || o.Loop > maxLoopCount {
+		return fmt.Errorf("loop must be between 0 (forever) and %d", maxLoopCount)
+	}
+	if o.From < 0 || (o.To >= 0 && o.To < o.From) {
+		return fmt.Errorf("invalid ply range: %d-%d", o.From, o.To)
+	}
+	if o.Steps < 0 || o.Steps > maxSteps {
+		return fmt.Errorf("animate must be between 0 and %d frames per move", maxSteps)
+	}
+	if o.StepDelay < 1 || o.StepDelay > maxFrameDelay {
+		return fmt.Errorf("step must be between 1 and %d centiseconds", maxFrameDelay)
+	}
+	if _, ok := easings[o.Easing]; !ok {
+		return fmt.Errorf("invalid easing: %s", o.Easing)
+	}
+	return nil
 }
 
-func prepareMoveRenderer(pos *chess.Position, move *chess.Move) (*chessimage.Renderer, error) {
-	r, err := chessimage.NewRendererFromFEN(pos.String())
+// MoveHistoryToGIF renders the positions of a game as an animated GIF
+func MoveHistoryToGIF(w io.Writer, game *chess.Game, opts GIFOptions) error {
+	a, err := newAnimation(game, opts)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	palette, err := opts.Board.Theme.gifPalette()
+	if err != nil {
+		return err
 	}
+	done := make(chan struct{})
+	defer close(done)
+	frames, errs := a.frames(func(img *image.RGBA) image.Image {
+		return toPaletted(img, palette)
+	}, done)
+	if err := convertImagesToGif(w, a.bounds(), frames, opts.Loop); err != nil {
+		return err
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// convertImagesToGif encodes the frames as they arrive, loop is the number of times the animation is played
+// (0 means forever)
+func convertImagesToGif(w io.Writer, size image.Point, frames <-chan internal.Frame, loop int) error {
+	return internal.Encode(w, size, frames, loopCount(loop))
+}
 
-	if move != nil {
-		from, _ := chessimage.TileFromAN(move.S1().String())
-		to, _ := chessimage.TileFromAN(move.S2().String())
-		r.SetLastMove(chessimage.LastMove{
-			From: from,
-			To:   to,
-		})
-		if move.HasTag(chess.Check) {
-			kingSq, _ := chessimage.TileFromAN(pos.Board().KingSquare(pos.Turn()).String())
-			r.SetCheckTile(kingSq)
+// toPaletted converts an image to the closest colors of a palette
+func toPaletted(img *image.RGBA, p color.Palette) *image.Paletted {
+	bounds := img.Bounds()
+	dst := image.NewPaletted(bounds, p)
+	indices := make(map[uint32]uint8)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			pix := img.Pix[i : i+4 : i+4]
+			key := uint32(pix[0])<<24 | uint32(pix[1])<<16 | uint32(pix[2])<<8 | uint32(pix[3])
+			index, ok := indices[key]
+			if !ok {
+				index = uint8(p.Index(color.RGBA{R: pix[0], G: pix[1], B: pix[2], A: pix[3]}))
+				indices[key] = index
+			}
+			dst.Pix[dst.PixOffset(x, y)] = index
 		}
 	}
+	return dst
+}
 
-	return r, nil
+// gifFrameCount returns the number of frames of a game's GIF
+func gifFrameCount(plies int, opts GIFOptions) int {
+	to := opts.To
+	if to < 0 || to > plies {
+		to = plies
+	}
+	if to < opts.From {
+		return 0
+	}
+	return to - opts.From + 1 + (to-opts.From)*opts.Steps
 }
 
-func convertImagesToGif(w io.Writer, images <-chan *image.Paletted, delay int) error {
-	return internal.Encode(w, image.Point{X: boardSize, Y: boardSize}, images, delay, -1)
+// loopCount converts the number of plays to the GIF loop count (0 is forever, -1 is once, n is n+1 times)
+func loopCount(loop int) int {
+	switch loop {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	default:
+		return loop - 1
+	}
 }
 
 func rgb(r, g, b uint8) color.Color {
 	return &color.RGBA{R: r, G: g, B: b, A: 255}
 }
 
+// mix returns the color halfway between two colors
 func mix(c1, c2 color.Color) color.Color {
 	r1, g1, b1, _ := c1.RGBA()
 	r2, g2, b2, _ := c2.RGBA()
-	return &color.RGBA{
-		R: uint8((r1 + r2) / 2),
-		G: uint8((g1 + g2) / 2),
-		B: uint8((b1 + b2) / 2),
+	return &color.RGBA{ // RGBA returns 16 bit channels
+		R: uint8((r1 + r2) / 2 >> 8),
+		G: uint8((g1 + g2) / 2 >> 8),
+		B: uint8((b1 + b2) / 2 >> 8),
 		A: 255,
 	}
 }
 
-func getPalette() []color.Color {
-	lightSq := rgb(240, 217, 181)
-	darkSq := rgb(181, 136, 99)
-	lightSqHigh := rgb(247, 193, 99)
-	darkSqHigh := rgb(215, 149, 54)
-	check := rgb(255, 0, 0)
-
-	var palette []color.Color
-	pieceColors := []color.Color{color.White, color.Black, &color.Gray{Y: 128}}
-	sqColors := []color.Color{lightSq, darkSq, lightSqHigh, darkSqHigh, check}
+// getPalette returns the GIF palette of a theme: the colors of its pieces, its square colors and their mixes
+func getPalette(theme *Theme) (color.Palette, error) {
+	pieceColors, err := getPieceColors(theme)
+	if err != nil {
+		return nil, err
+	}
+	var palette color.Palette
+	sqColors := []color.Color{theme.Light, theme.Dark, theme.HighlightLight, theme.HighlightDark, theme.Check}
+	// the overlays have their own colors, so the board colors aren't approximated with them
+	textColors := []color.Color{colorBar, colorText, colorTextMuted}
 
 	palette = append(palette, pieceColors...)
 	palette = append(palette, sqColors...)
@@ -111,5 +220,57 @@ func getPalette() []color.Color {
 			palette = append(palette, mix(pieceColor, sqColor))
 		}
 	}
-	return palette
+	palette = append(palette, textColors...)
+	return palette, nil
+}
+
+// getPieceColors returns the most common colors of a theme's piece images (at most maxPieceColors of them),
+// the similar colors are counted together
+func getPieceColors(theme *Theme) ([]color.Color, error) {
+	pieces, err := getPieceImages(theme, boardSize/8)
+	if err != nil {
+		return nil, err
+	}
+	type colorCount struct {
+		key              uint16
+		count            int
+		sumR, sumG, sumB int
+	}
+	counts := make(map[uint16]*colorCount)
+	for _, img := range pieces {
+		for i := 0; i < len(img.Pix); i += 4 {
+			pix := img.Pix[i : i+4 : i+4]
+			if pix[3] != 255 {
+				continue // the edges are drawn with the mixes of the piece and square colors
+			}
+			key := uint16(pix[0]>>4)<<8 | uint16(pix[1]>>4)<<4 | uint16(pix[2]>>4)
+			c, ok := counts[key]
+			if !ok {
+				c = &colorCount{key: key}
+				counts[key] = c
+			}
+			c.count++
+			c.sumR += int(pix[0])
+			c.sumG += int(pix[1])
+			c.sumB += int(pix[2])
+		}
+	}
+	sorted := make([]*colorCount, 0, len(counts))
+	for _, c := range counts {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+	if len(sorted) > maxPieceColors {
+		sorted = sorted[:maxPieceColors]
+	}
+	colors := make([]color.Color, len(sorted))
+	for i, c := range sorted {
+		colors[i] = rgb(uint8(c.sumR/c.count), uint8(c.sumG/c.count), uint8(c.sumB/c.count))
+	}
+	return colors, nil
 }
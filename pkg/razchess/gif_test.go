@@ -0,0 +1,55 @@
+package razchess
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestMix(t *testing.T) {
+	tests := []struct {
+		c1, c2   color.Color
+		expected color.RGBA
+	}{
+		{rgb(0, 0, 0), rgb(255, 255, 255), color.RGBA{127, 127, 127, 255}},
+		{rgb(240, 217, 181), rgb(181, 136, 99), color.RGBA{211, 177, 140, 255}},
+		{rgb(255, 0, 0), rgb(0, 0, 255), color.RGBA{127, 0, 127, 255}},
+	}
+	for _, test := range tests {
+		if c := rgbaOf(mix(test.c1, test.c2)); c != test.expected {
+			t.Errorf("mix(%v, %v) = %v, expected %v", test.c1, test.c2, c, test.expected)
+		}
+	}
+}
+
+// TestPalette checks that the GIF palettes contain the midpoints of the piece and square colors
+func TestPalette(t *testing.T) {
+	for _, name := range ThemeNames() {
+		theme := themes[name]
+		palette, err := theme.gifPalette()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pieceColors, err := getPieceColors(theme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pieceColors) == 0 || len(palette) > 256 {
+			t.Fatalf("%s: %d piece colors, %d palette colors", name, len(pieceColors), len(palette))
+		}
+		p, light := rgbaOf(pieceColors[0]), rgbaOf(theme.Light)
+		// the midpoint may be rounded either way
+		midpoint := func(c1, c2 uint8) int { return (int(c1) + int(c2)) / 2 }
+		near := func(c uint8, mid int) bool { return int(c) == mid || int(c) == mid+1 }
+		found := false
+		for _, c := range palette {
+			c := rgbaOf(c)
+			if near(c.R, midpoint(p.R, light.R)) && near(c.G, midpoint(p.G, light.G)) && near(c.B, midpoint(p.B, light.B)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%s: the palette is missing the mix of %v and %v", name, p, light)
+		}
+	}
+}
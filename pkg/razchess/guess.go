@@ -0,0 +1,284 @@
+package razchess
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/engine"
+)
+
+const (
+	GuessExactPoints   = 10
+	GuessMaxPoints     = 7 // for a different move that the engine finds as good as the game move
+	guessCentipawnStep = 50
+	guessMoveTime      = 300 * time.Millisecond
+	guessMaxDepth      = 12
+)
+
+// MoveGuess is a guess of a move in guess-the-move mode
+type MoveGuess struct {
+	Ply        int    `json:"ply"`
+	Guess      string `json:"guess"`
+	Actual     string `json:"actual"`
+	Points     int    `json:"points"`
+	GuessEval  string `json:"guessEval,omitempty"`
+	ActualEval string `json:"actualEval,omitempty"`
+}
+
+// GuessTheMove is the state of a guess-the-move session
+type GuessTheMove struct {
+	White     string       `json:"white,omitempty"`
+	Black     string       `json:"black,omitempty"`
+	Event     string       `json:"event,omitempty"`
+	Color     string       `json:"color"`
+	Score     int          `json:"score"`
+	MaxScore  int          `json:"maxScore"`
+	MovesLeft int          `json:"movesLeft"`
+	Guesses   []*MoveGuess `json:"guesses"`
+	IsOver    bool         `json:"isOver"`
+}
+
+type guessTheMove struct {
+	state     GuessTheMove
+	game      *chess.Game
+	moves     []*chess.Move
+	color     chess.Color
+	fromPly   int
+	useEngine bool
+	summary   string
+}
+
+func newGuessTheMove(pgn string, color chess.Color, fromMove int, useEngine bool) (*guessTheMove, error) {
+	opts, err := parseGame("pgn:" + pgn)
+	if err != nil {
+		return nil, err
+	}
+	game := chess.NewGame(opts...)
+	moves := game.Moves()
+	if color == chess.NoColor {
+		color = chess.White
+		if game.Outcome() == chess.BlackWon {
+			color = chess.Black
+		}
+	}
+	fromPly := 0
+	if fromMove > 1 {
+		fromPly = (fromMove - 1) * 2
+	}
+	g := &guessTheMove{
+		state: GuessTheMove{
+			White:   getTagValue(game, "White"),
+			Black:   getTagValue(game, "Black"),
+			Event:   getTagValue(game, "Event"),
+			Color:   color.Name(),
+			Guesses: []*MoveGuess{},
+		},
+		game:      game,
+		moves:     moves,
+		color:     color,
+		fromPly:   fromPly,
+		useEngine: useEngine,
+	}
+	if g.countGuesses() == 0 {
+		return nil, fmt.Errorf("there are no %s moves to guess", strings.ToLower(color.Name()))
+	}
+	return g, nil
+}
+
+func (g *guessTheMove) start(sess *Session) {
+	sess.game = newGameFromFEN(g.game.Positions()[0].String())
+	g.state.MovesLeft = g.countGuesses()
+	g.playUntilGuess(sess, false)
+}
+
+func (g *guessTheMove) handleMove(sess *Session, move *chess.Move) bool {
+	pos := sess.game.Position()
+	ply := len(sess.game.Moves())
+	if g.state.IsOver || pos.Turn() != g.color || ply >= len(g.moves) {
+		return false
+	}
+	actual := g.moves[ply]
+	guess := &MoveGuess{
+		Ply:    ply,
+		Guess:  chess.AlgebraicNotation{}.Encode(pos, move),
+		Actual: chess.AlgebraicNotation{}.Encode(pos, actual),
+	}
+	if move.S1() == actual.S1() && move.S2() == actual.S2() && move.Promo() == actual.Promo() {
+		guess.Points = GuessExactPoints
+	} else if g.useEngine {
+		guessEval := evaluateMove(pos, move)
+		actualEval := evaluateMove(pos, actual)
+		if guessEval != nil && actualEval != nil {
+			guess.GuessEval = guessEval.String()
+			guess.ActualEval = actualEval.String()
+			guess.Points = guessPoints(guessEval, actualEval)
+		}
+	}
+	g.state.Guesses = append(g.state.Guesses, guess)
+	g.state.Score += guess.Points
+	g.state.MaxScore += GuessExactPoints
+	g.state.MovesLeft--
+
+	sess.handleMove(actual)
+	sess.updateClients()
+	g.playUntilGuess(sess, true)
+	return true
+}
+
+func (g *guessTheMove) handleResign(sess *Session, color chess.Color) {
+	if !g.state.IsOver && color == g.color {
+		g.finish()
+	}
+}
+
+func (g *guessTheMove) decorate(u *Update) {
+	state := g.state
+	u.GuessTheMove = &state
+	u.Orientation = strings.ToLower(state.Color)
+	u.Tablebase = nil
+	if len(state.Guesses) > 0 {
+		last := state.Guesses[len(state.Guesses)-1]
+		u.Status = fmt.Sprintf("You guessed %s, the game move was %s (%d points) - %s", last.Guess, last.Actual, last.Points, u.Status)
+	}
+	if state.IsOver {
+		u.IsGameOver = true
+		u.Status = fmt.Sprintf("Game over: %d/%d points", state.Score, state.MaxScore)
+		u.PGN = g.summary
+	}
+}
+
+// playUntilGuess plays the game moves until it's the trainee's turn again
+func (g *guessTheMove) playUntilGuess(sess *Session, wait bool) {
+	for ply := len(sess.game.Moves()); ply < len(g.moves); ply++ {
+		if ply >= g.fromPly && sess.game.Position().Turn() == g.color {
+			return
+		}
+		if wait {
+			<-time.NewTimer(time.Second).C
+		}
+		sess.handleMove(g.moves[ply])
+		if wait {
+			sess.updateClients()
+		}
+	}
+	g.finish()
+	if wait {
+		sess.updateClients()
+	}
+}
+
+func (g *guessTheMove) finish() {
+	g.state.IsOver = true
+	g.state.MovesLeft = 0
+	g.summary = g.summaryPGN()
+}
+
+func (g *guessTheMove) countGuesses() (count int) {
+	positions := g.game.Positions()
+	for ply := g.fromPly; ply < len(g.moves); ply++ {
+		if positions[ply].Turn() == g.color {
+			count++
+		}
+	}
+	return
+}
+
+var (
+	pgnTagUnescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`)
+	pgnTagEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// pgnTagValue escapes the quotes and backslashes of a tag value. The values parsed from PGN keep their escape
+// sequences, so they are unescaped first.
+func pgnTagValue(value string) string {
+	return pgnTagEscaper.Replace(pgnTagUnescaper.Replace(value))
+}
+
+// fullmoveNumber returns the number of the move in a position (the last field of its FEN)
+func fullmoveNumber(pos *chess.Position) int {
+	fields := strings.Fields(pos.String())
+	n, _ := strconv.Atoi(fields[len(fields)-1])
+	return n
+}
+
+// summaryPGN returns the original game annotated with the guesses
+func (g *guessTheMove) summaryPGN() string {
+	var sb strings.Builder
+	for _, tag := range g.game.TagPairs() {
+		if tag.Key != "Annotator" {
+			fmt.Fprintf(&sb, "[%s \"%s\"]\n", tag.Key, pgnTagValue(tag.Value))
+		}
+	}
+	fmt.Fprintf(&sb, "[Annotator \"RazChess guess the move: %d/%d points\"]\n\n", g.state.Score, g.state.MaxScore)
+
+	guesses := make(map[int]*MoveGuess)
+	for _, guess := range g.state.Guesses {
+		guesses[guess.Ply] = guess
+	}
+	positions := g.game.Positions()
+	for ply, move := range g.moves {
+		pos := positions[ply]
+		if pos.Turn() == chess.White {
+			fmt.Fprintf(&sb, "%d. ", fullmoveNumber(pos))
+		} else if ply == 0 || guesses[ply-1] != nil {
+			fmt.Fprintf(&sb, "%d... ", fullmoveNumber(pos))
+		}
+		sb.WriteString(chess.AlgebraicNotation{}.Encode(pos, move))
+		sb.WriteByte(' ')
+		if guess := guesses[ply]; guess != nil {
+			fmt.Fprintf(&sb, "{Guess: %s", guess.Guess)
+			if len(guess.GuessEval) > 0 {
+				fmt.Fprintf(&sb, " (%s vs %s)", guess.GuessEval, guess.ActualEval)
+			}
+			fmt.Fprintf(&sb, ", %d points} ", guess.Points)
+		}
+	}
+	sb.WriteString(g.game.Outcome().String())
+	return sb.String()
+}
+
+// guessPoints scores a guess that is different from the game move based on the engine evaluations
+func guessPoints(guess, actual *engine.Evaluation) int {
+	diff := evalCentipawns(actual) - evalCentipawns(guess)
+	if diff <= 0 {
+		return GuessMaxPoints
+	}
+	if points := GuessMaxPoints - diff/guessCentipawnStep; points > 0 {
+		return points
+	}
+	return 0
+}
+
+func evalCentipawns(e *engine.Evaluation) int {
+	switch {
+	case e.Mate > 0:
+		return 100000 - e.Mate
+	case e.Mate < 0:
+		return -100000 - e.Mate
+	default:
+		return e.Score
+	}
+}
+
+// evaluateMove evaluates a move from the point of view of the side playing it
+func evaluateMove(pos *chess.Position, move *chess.Move) *engine.Evaluation {
+	next := pos.Update(move)
+	switch next.Status() {
+	case chess.Checkmate:
+		return &engine.Evaluation{Mate: 1}
+	case chess.Stalemate:
+		return &engine.Evaluation{}
+	}
+	e, err := engine.Analyze(next.String(), guessMoveTime, guessMaxDepth)
+	if err != nil {
+		return nil
+	}
+	e.Score, e.Mate = -e.Score, -e.Mate
+	if e.Mate > 0 {
+		e.Mate++ // the reply of the opponent was not counted yet
+	}
+	return e
+}
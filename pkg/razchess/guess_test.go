@@ -0,0 +1,35 @@
+package razchess
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// TestGuessSummaryPGN checks the escaped tag values and the move numbers of a game starting from a FEN
+func TestGuessSummaryPGN(t *testing.T) {
+	pgn := `[Event "The \"Immortal\" game"]
+[Site "C:\\Games \"Online\""]
+[SetUp "1"]
+[FEN "4k3/8/8/8/8/8/4P3/4K3 b - - 0 20"]
+
+20... Kd7 21. e4 Kc6 *`
+	g, err := newGuessTheMove(pgn, chess.White, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.game.AddTagPair("White", `Dan "The Man" O'Brien`)
+	g.state.Guesses = []*MoveGuess{{Ply: 1, Guess: "e3", Actual: "e4"}}
+	summary := g.summaryPGN()
+	for _, expected := range []string{
+		`[Event "The \"Immortal\" game"]`,
+		`[Site "C:\\Games \"Online\""]`,
+		`[White "Dan \"The Man\" O'Brien"]`,
+		"20... Kd7 21. e4 {Guess: e3, 0 points} 21... Kc6 *",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("the summary doesn't contain %s:\n%s", expected, summary)
+		}
+	}
+}
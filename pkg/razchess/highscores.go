@@ -0,0 +1,117 @@
+package razchess
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const highScoresKey = "highscores"
+
+type HighScore struct {
+	Nickname string    `json:"nickname"`
+	Mode     string    `json:"mode"`
+	Score    int       `json:"score"`
+	Date     time.Time `json:"date"`
+}
+
+// highScores keeps the best score of each nickname in each puzzle run mode
+type highScores struct {
+	mtx     sync.Mutex
+	saveMtx sync.Mutex // keeps the saves in order
+	db      Storage
+	scores  map[string]map[string]*HighScore // mode -> nickname -> best score
+}
+
+func newHighScores(db Storage) *highScores {
+	hs := &highScores{
+		db:     db,
+		scores: make(map[string]map[string]*HighScore),
+	}
+	if db != nil {
+		if data, ok := db.LoadRecord(highScoresKey); ok {
+			var scores []*HighScore
+			if err := json.Unmarshal([]byte(data), &scores); err != nil {
+				log.Println("failed to load high scores:", err)
+			}
+			for _, score := range scores {
+				hs.set(score)
+			}
+		}
+	}
+	return hs
+}
+
+// submit records a score and returns the best score of the nickname in the mode
+func (hs *highScores) submit(mode, nickname string, score int) int {
+	hs.mtx.Lock()
+	defer hs.mtx.Unlock()
+	if best := hs.scores[mode][nickname]; best != nil && best.Score >= score {
+		return best.Score
+	}
+	hs.set(&HighScore{
+		Nickname: nickname,
+		Mode:     mode,
+		Score:    score,
+		Date:     time.Now().UTC(),
+	})
+	if hs.db != nil {
+		go hs.save()
+	}
+	return score
+}
+
+// save saves the current high scores, so a save that finishes later never writes an older snapshot
+func (hs *highScores) save() {
+	hs.saveMtx.Lock()
+	defer hs.saveMtx.Unlock()
+	hs.mtx.Lock()
+	data, _ := json.Marshal(hs.list(""))
+	hs.mtx.Unlock()
+	hs.db.SaveRecord(highScoresKey, string(data))
+}
+
+func (hs *highScores) best(mode, nickname string) int {
+	hs.mtx.Lock()
+	defer hs.mtx.Unlock()
+	if best := hs.scores[mode][nickname]; best != nil {
+		return best.Score
+	}
+	return 0
+}
+
+// ranking returns the high scores of a mode (or all modes if empty) in descending order
+func (hs *highScores) ranking(mode string) []*HighScore {
+	hs.mtx.Lock()
+	defer hs.mtx.Unlock()
+	return hs.list(mode)
+}
+
+func (hs *highScores) set(score *HighScore) {
+	scores, ok := hs.scores[score.Mode]
+	if !ok {
+		scores = make(map[string]*HighScore)
+		hs.scores[score.Mode] = scores
+	}
+	scores[score.Nickname] = score
+}
+
+func (hs *highScores) list(mode string) (list []*HighScore) {
+	for m, scores := range hs.scores {
+		if len(mode) > 0 && m != mode {
+			continue
+		}
+		for _, score := range scores {
+			list = append(list, score)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].Date.Before(list[j].Date)
+	})
+	return
+}
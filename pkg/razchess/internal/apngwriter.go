@@ -0,0 +1,260 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+)
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// APNG dispose and blend operations
+const (
+	apngDisposeNone = 0
+	apngBlendSource = 0
+	apngBlendOver   = 1
+)
+
+// apngEncoder writes the chunks of an animated PNG
+type apngEncoder struct {
+	w   *bufio.Writer
+	err error
+	seq uint32 // sequence number of the fcTL and fdAT chunks
+}
+
+func (e *apngEncoder) writeChunk(name string, data []byte) {
+	if e.err != nil {
+		return
+	}
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+	for _, b := range [][]byte{header[:], data, footer[:]} {
+		if _, e.err = e.w.Write(b); e.err != nil {
+			return
+		}
+	}
+}
+
+func (e *apngEncoder) writeHeader(bounds image.Point, numFrames, numPlays int) {
+	if _, e.err = io.WriteString(e.w, pngHeader); e.err != nil {
+		return
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(bounds.X))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(bounds.Y))
+	ihdr[8] = 8  // bit depth
+	ihdr[9] = 6  // color type: truecolor with alpha
+	ihdr[10] = 0 // compression method
+	ihdr[11] = 0 // filter method
+	ihdr[12] = 0 // interlace method
+	e.writeChunk("IHDR", ihdr)
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], uint32(numFrames))
+	binary.BigEndian.PutUint32(actl[4:8], uint32(numPlays))
+	e.writeChunk("acTL", actl)
+}
+
+func (e *apngEncoder) writeFrame(img *image.RGBA, delay int, blend byte, first bool) {
+	b := img.Bounds()
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:4], e.seq)
+	binary.BigEndian.PutUint32(fctl[4:8], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:12], uint32(b.Dy()))
+	binary.BigEndian.PutUint32(fctl[12:16], uint32(b.Min.X))
+	binary.BigEndian.PutUint32(fctl[16:20], uint32(b.Min.Y))
+	binary.BigEndian.PutUint16(fctl[20:22], uint16(delay))
+	binary.BigEndian.PutUint16(fctl[22:24], 100) // the delay is in 100ths of a second
+	fctl[24] = apngDisposeNone
+	fctl[25] = blend
+	e.writeChunk("fcTL", fctl)
+	e.seq++
+
+	data, err := compressImage(img)
+	if err != nil {
+		if e.err == nil {
+			e.err = err
+		}
+		return
+	}
+	if first {
+		e.writeChunk("IDAT", data)
+		return
+	}
+	fdat := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(fdat[0:4], e.seq)
+	copy(fdat[4:], data)
+	e.writeChunk("fdAT", fdat)
+	e.seq++
+}
+
+// compressImage returns the filtered and compressed pixel data of an image
+func compressImage(img *image.RGBA) ([]byte, error) {
+	b := img.Bounds()
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	const bpp = 4 // bytes per pixel
+	rowLen := bpp * b.Dx()
+	prev := make([]byte, rowLen)
+	filtered := make([]byte, 1+rowLen)
+	best := make([]byte, 1+rowLen)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y) : img.PixOffset(b.Min.X, y)+rowLen]
+		// the filter with the smallest sum of absolute differences usually compresses the best
+		bestSum := -1
+		for filter := byte(0); filter <= 4; filter++ {
+			filtered[0] = filter
+			sum := 0
+			for i := 0; i < rowLen; i++ {
+				var left, up, upLeft byte
+				if i >= bpp {
+					left, upLeft = row[i-bpp], prev[i-bpp]
+				}
+				up = prev[i]
+				var predicted byte
+				switch filter {
+				case 1:
+					predicted = left
+				case 2:
+					predicted = up
+				case 3:
+					predicted = byte((int(left) + int(up)) / 2)
+				case 4:
+					predicted = paeth(left, up, upLeft)
+				}
+				d := row[i] - predicted
+				filtered[1+i] = d
+				if d < 128 {
+					sum += int(d)
+				} else {
+					sum += 256 - int(d)
+				}
+			}
+			if bestSum < 0 || sum < bestSum {
+				bestSum = sum
+				copy(best, filtered)
+			}
+		}
+		if _, err := zw.Write(best); err != nil {
+			return nil, err
+		}
+		copy(prev, row)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// toRGBA returns the image as an RGBA image
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// deltaRGBA returns the smallest area of img that differs from prev with the unchanged pixels being transparent
+func deltaRGBA(prev, img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	changed := image.Rectangle{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		prevRow := prev.Pix[prev.PixOffset(b.Min.X, y):prev.PixOffset(b.Max.X, y)]
+		if bytes.Equal(row, prevRow) {
+			continue
+		}
+		minX, maxX := 0, len(row)/4-1
+		for bytes.Equal(row[minX*4:minX*4+4], prevRow[minX*4:minX*4+4]) {
+			minX++
+		}
+		for bytes.Equal(row[maxX*4:maxX*4+4], prevRow[maxX*4:maxX*4+4]) {
+			maxX--
+		}
+		changed = changed.Union(image.Rect(b.Min.X+minX, y, b.Min.X+maxX+1, y+1))
+	}
+	if changed.Empty() {
+		// a frame is still needed for its delay
+		changed = image.Rect(0, 0, 1, 1)
+	}
+
+	sub := image.NewRGBA(changed)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			i, j := img.PixOffset(x, y), sub.PixOffset(x, y)
+			if !bytes.Equal(img.Pix[i:i+4], prev.Pix[i:i+4]) {
+				copy(sub.Pix[j:j+4], img.Pix[i:i+4])
+			}
+		}
+	}
+	return sub
+}
+
+// EncodeAPNG writes the frames as an animated PNG as they arrive, numFrames is the number of frames to expect
+// and numPlays is the number of times the animation is played (0 means forever).
+// The frames after the first one only contain the area that changed, with the unchanged pixels in it transparent.
+func EncodeAPNG(w io.Writer, bounds image.Point, frames <-chan Frame, numFrames, numPlays int) error {
+	e := apngEncoder{w: bufio.NewWriter(w)}
+	e.writeHeader(bounds, numFrames, numPlays)
+	var prev *image.RGBA
+	written := 0
+	for f := range frames {
+		img := toRGBA(f.Image)
+		if img.Bounds() != image.Rect(0, 0, bounds.X, bounds.Y) {
+			if e.err == nil {
+				e.err = errors.New("apng: frame bounds differ from the animation's bounds")
+			}
+			continue
+		}
+		if prev == nil {
+			e.writeFrame(img, f.Delay, apngBlendSource, true)
+		} else {
+			e.writeFrame(deltaRGBA(prev, img), f.Delay, apngBlendOver, false)
+		}
+		prev = img
+		written++
+	}
+	if e.err == nil && written != numFrames {
+		e.err = errors.New("apng: the number of frames differs from the expected count")
+	}
+	e.writeChunk("IEND", nil)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
@@ -80,6 +80,8 @@ type encoder struct {
 	buf              [256]byte
 	globalColorTable [3 * 256]byte
 	localColorTable  [3 * 256]byte
+	// streaming is true if the number of images is not known in advance
+	streaming bool
 }
 
 // blockWriter writes the block structure of GIF image data, which
@@ -198,7 +200,7 @@ func (e *encoder) writeHeader() {
 	}
 
 	// Add animation info if necessary.
-	if len(e.g.Image) > 1 && e.g.LoopCount >= 0 {
+	if (e.streaming || len(e.g.Image) > 1) && e.g.LoopCount >= 0 {
 		e.buf[0] = 0x21 // Extension Introducer.
 		e.buf[1] = 0xff // Application Label.
 		e.buf[2] = 0x0b // Block Size.
@@ -402,20 +404,115 @@ type GIF struct {
 	BackgroundIndex byte
 }
 
-func Encode(w io.Writer, bounds image.Point, images <-chan *image.Paletted, delay, loopCount int) error {
+// Frame is an image of an animation and its delay in 100ths of a second
+// (the GIF encoder expects paletted images)
+type Frame struct {
+	Image image.Image
+	Delay int
+}
+
+// Encode writes the frames as they arrive, loopCount has the same meaning as GIF.LoopCount.
+// The frames after the first one only contain the area that changed, with the unchanged pixels in it transparent.
+func Encode(w io.Writer, bounds image.Point, frames <-chan Frame, loopCount int) error {
 	e := encoder{}
 	e.g.Config.Width = bounds.X
 	e.g.Config.Height = bounds.Y
+	e.g.LoopCount = loopCount
+	e.streaming = true
 	if ww, ok := w.(writer); ok {
 		e.w = ww
 	} else {
 		e.w = bufio.NewWriter(w)
 	}
+
+	// the palette of the first frame becomes the global color table with an extra transparent color
+	var first *image.Paletted
+	var palette color.Palette
+	transparent := -1
+	f, ok := <-frames
+	if ok {
+		if first, ok = f.Image.(*image.Paletted); !ok {
+			return errors.New("gif: frames must be paletted images")
+		}
+		palette = append(palette, first.Palette...)
+		if len(palette) < 256 {
+			transparent = len(palette)
+			palette = append(palette, color.RGBA{})
+		}
+		e.g.Config.ColorModel = palette
+	}
 	e.writeHeader()
-	for pm := range images {
-		e.writeImageBlock(pm, delay, 0)
+
+	var prev *image.Paletted
+	for ; ok; f, ok = <-frames {
+		img, isPaletted := f.Image.(*image.Paletted)
+		if !isPaletted {
+			if e.err == nil {
+				e.err = errors.New("gif: frames must be paletted images")
+			}
+			continue
+		}
+		if !samePalette(img.Palette, first.Palette) || img.Bounds() != image.Rect(0, 0, bounds.X, bounds.Y) {
+			e.writeImageBlock(img, f.Delay, 0)
+			prev = nil
+			continue
+		}
+		if prev == nil {
+			e.writeImageBlock(withPalette(img, palette), f.Delay, DisposalNone)
+		} else {
+			e.writeImageBlock(delta(prev, img, palette, transparent), f.Delay, DisposalNone)
+		}
+		prev = img
 	}
 	e.writeByte(sTrailer)
 	e.flush()
 	return e.err
 }
+
+func samePalette(p1, p2 color.Palette) bool {
+	return len(p1) == len(p2) && len(p1) > 0 && &p1[0] == &p2[0]
+}
+
+// withPalette returns the image using a palette that starts with the same colors as its own
+func withPalette(img *image.Paletted, palette color.Palette) *image.Paletted {
+	return &image.Paletted{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect, Palette: palette}
+}
+
+// delta returns the smallest area of img that differs from prev with the unchanged pixels being transparent
+// (if the palette has a transparent color)
+func delta(prev, img *image.Paletted, palette color.Palette, transparent int) *image.Paletted {
+	b := img.Bounds()
+	changed := image.Rectangle{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		prevRow := prev.Pix[prev.PixOffset(b.Min.X, y):prev.PixOffset(b.Max.X, y)]
+		if bytes.Equal(row, prevRow) {
+			continue
+		}
+		minX, maxX := 0, len(row)-1
+		for row[minX] == prevRow[minX] {
+			minX++
+		}
+		for row[maxX] == prevRow[maxX] {
+			maxX--
+		}
+		changed = changed.Union(image.Rect(b.Min.X+minX, y, b.Min.X+maxX+1, y+1))
+	}
+	if changed.Empty() {
+		// a frame is still needed for its delay
+		changed = image.Rect(0, 0, 1, 1)
+	}
+
+	sub := image.NewPaletted(changed, palette)
+	for y := changed.Min.Y; y < changed.Max.Y; y++ {
+		for x := changed.Min.X; x < changed.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			if transparent >= 0 && img.Pix[i] == prev.Pix[i] {
+				sub.Pix[sub.PixOffset(x, y)] = uint8(transparent)
+			} else {
+				sub.Pix[sub.PixOffset(x, y)] = img.Pix[i]
+			}
+		}
+	}
+	return sub
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+// TestEncodeDeltaFrames checks the areas of the frames after the first one and that they still compose
+// into the original images
+func TestEncodeDeltaFrames(t *testing.T) {
+	palette := color.Palette{color.White, color.Black, color.RGBA{R: 255, A: 255}}
+	size := image.Pt(8, 8)
+	newFrame := func(pixels map[image.Point]uint8) *image.Paletted {
+		img := image.NewPaletted(image.Rect(0, 0, size.X, size.Y), palette)
+		for p, c := range pixels {
+			img.SetColorIndex(p.X, p.Y, c)
+		}
+		return img
+	}
+	tests := []struct {
+		image *image.Paletted
+		area  image.Rectangle // encoded area
+	}{
+		{newFrame(nil), image.Rect(0, 0, 8, 8)},
+		{newFrame(map[image.Point]uint8{{2, 3}: 1, {5, 4}: 2}), image.Rect(2, 3, 6, 5)},
+		{newFrame(map[image.Point]uint8{{2, 3}: 1, {5, 4}: 2}), image.Rect(0, 0, 1, 1)}, // unchanged
+		{newFrame(map[image.Point]uint8{{7, 7}: 1}), image.Rect(2, 3, 8, 8)},
+	}
+	frames := make(chan Frame, len(tests))
+	for _, test := range tests {
+		frames <- Frame{Image: test.image, Delay: 10}
+	}
+	close(frames)
+	var buf bytes.Buffer
+	if err := Encode(&buf, size, frames, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != len(tests) {
+		t.Fatalf("%d frames decoded, expected %d", len(decoded.Image), len(tests))
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for i, test := range tests {
+		frame := decoded.Image[i]
+		if frame.Bounds() != test.area {
+			t.Errorf("frame %d: area %v, expected %v", i, frame.Bounds(), test.area)
+		}
+		if decoded.Delay[i] != 10 || decoded.Disposal[i] != DisposalNone {
+			t.Errorf("frame %d: delay %d and disposal %d, expected 10 and %d", i, decoded.Delay[i], decoded.Disposal[i], DisposalNone)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		for y := 0; y < size.Y; y++ {
+			for x := 0; x < size.X; x++ {
+				r1, g1, b1, _ := canvas.At(x, y).RGBA()
+				r2, g2, b2, _ := test.image.At(x, y).RGBA()
+				if r1 != r2 || g1 != g2 || b1 != b2 {
+					t.Fatalf("frame %d: the pixel at %d,%d differs from the original", i, x, y)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package razchess
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/opening"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Overlays are the optional texts drawn around the board in exported GIFs
+type Overlays struct {
+	Caption bool // move number and SAN of the last move
+	Players bool // player names and the result banner on the last frame
+	Opening bool // opening name from the ECO book
+	Clocks  bool // clock times from [%clk] comments
+}
+
+var (
+	colorBar       = rgb(38, 36, 33)
+	colorText      = rgb(255, 255, 255)
+	colorTextMuted = rgb(170, 170, 170)
+)
+
+var clockRegex = regexp.MustCompile(`\[%clk\s+([0-9:.]+)\]`)
+
+const (
+	glyphWidth  = 7 // of basicfont.Face7x13
+	glyphHeight = 13
+	barPadding  = 5
+)
+
+// parseOverlays reads a comma separated list of overlays like caption,players,opening,clock (or all)
+func parseOverlays(s string) (Overlays, error) {
+	var o Overlays
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "all":
+			o = Overlays{Caption: true, Players: true, Opening: true, Clocks: true}
+		case "caption":
+			o.Caption = true
+		case "players":
+			o.Players = true
+		case "opening":
+			o.Opening = true
+		case "clock", "clocks":
+			o.Clocks = true
+		default:
+			return o, fmt.Errorf("invalid overlay: %s (expected caption, players, opening, clock or all)", name)
+		}
+	}
+	return o, nil
+}
+
+// overlayRenderer draws the overlays of a game's frames
+type overlayRenderer struct {
+	Overlays
+	board     BoardOptions
+	scale     int // of the text
+	barHeight int
+	moves     []*chess.Move
+	positions []*chess.Position
+	players   [2]string // white and black
+	result    string
+	openings  []*opening.Opening // by ply
+	clocks    [2][]string        // white's and black's clock by ply
+}
+
+func newOverlayRenderer(game *chess.Game, overlays Overlays, board BoardOptions) *overlayRenderer {
+	scale := board.Size / 256
+	if scale < 1 {
+		scale = 1
+	}
+	r := &overlayRenderer{
+		Overlays:  overlays,
+		board:     board,
+		scale:     scale,
+		barHeight: (glyphHeight + barPadding) * scale,
+		moves:     game.Moves(),
+		positions: game.Positions(),
+	}
+	for i, tag := range []string{"White", "Black"} {
+		r.players[i] = tag
+		if t := game.GetTagPair(tag); t != nil && len(t.Value) > 0 && t.Value != "?" {
+			r.players[i] = t.Value
+		}
+	}
+	if game.Outcome() != chess.NoOutcome {
+		r.result = resultText(game)
+	}
+	if overlays.Opening {
+		r.openings = openingsByPly(r.positions)
+	}
+	if overlays.Clocks {
+		r.clocks = clocksByPly(game, r.positions)
+	}
+	return r
+}
+
+// resultText describes the result of a finished game like 1-0 White wins by checkmate
+func resultText(game *chess.Game) string {
+	text := game.Outcome().String()
+	switch game.Outcome() {
+	case chess.WhiteWon:
+		text += " White wins"
+	case chess.BlackWon:
+		text += " Black wins"
+	case chess.Draw:
+		text += " Draw"
+		if method, ok := drawMethods[game.Method()]; ok {
+			text += " by " + method
+		}
+		return text
+	}
+	switch game.Method() {
+	case chess.Checkmate:
+		text += " by checkmate"
+	case chess.Resignation:
+		text += " by resignation"
+	}
+	return text
+}
+
+// clocksByPly returns the latest clock time of both sides at each position
+func clocksByPly(game *chess.Game, positions []*chess.Position) [2][]string {
+	var clocks [2][]string
+	clocks[0] = make([]string, len(positions))
+	clocks[1] = make([]string, len(positions))
+	comments := game.Comments()
+	var current [2]string
+	for ply := range positions {
+		if ply > 0 && ply-1 < len(comments) {
+			side := 0
+			if positions[ply-1].Turn() == chess.Black {
+				side = 1
+			}
+			for _, c := range comments[ply-1] {
+				if m := clockRegex.FindStringSubmatch(c); m != nil {
+					current[side] = formatClock(m[1])
+				}
+			}
+		}
+		clocks[0][ply], clocks[1][ply] = current[0], current[1]
+	}
+	return clocks
+}
+
+// formatClock drops the hours of clock times below an hour, so 0:03:05 becomes 3:05
+func formatClock(clock string) string {
+	if strings.HasPrefix(clock, "0:") {
+		clock = clock[2:]
+		if len(clock) > 1 && clock[0] == '0' && clock[1] != ':' {
+			clock = clock[1:]
+		}
+	}
+	return clock
+}
+
+func (r *overlayRenderer) hasPlayerBars() bool {
+	return r.Players || r.Clocks
+}
+
+func (r *overlayRenderer) hasCaptionBar() bool {
+	return r.Caption || r.Opening
+}
+
+// top returns the height of the area above the board
+func (r *overlayRenderer) top() int {
+	if r.hasPlayerBars() {
+		return r.barHeight
+	}
+	return 0
+}
+
+// bottom returns the height of the area below the board
+func (r *overlayRenderer) bottom() int {
+	height := 0
+	if r.hasPlayerBars() {
+		height += r.barHeight
+	}
+	if r.hasCaptionBar() {
+		height += r.barHeight
+	}
+	return height
+}
+
+// bounds returns the size of the frames
+func (r *overlayRenderer) bounds() image.Point {
+	return image.Point{X: r.board.Size, Y: r.top() + r.board.Size + r.bottom()}
+}
+
+// render returns the frame of a ply with the board image and the overlays
+func (r *overlayRenderer) render(ply int, board *image.RGBA) *image.RGBA {
+	if r.Overlays == (Overlays{}) {
+		return board
+	}
+	size := r.bounds()
+	img := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	draw.Draw(img, img.Bounds(), image.NewUniform(colorBar), image.Point{}, draw.Src)
+	boardRect := image.Rect(0, r.top(), r.board.Size, r.top()+r.board.Size)
+	draw.Draw(img, boardRect, board, image.Point{}, draw.Src)
+
+	if r.hasPlayerBars() {
+		top, bottom := 1, 0 // black's bar on the top unless the board is inverted
+		if r.board.Inverted {
+			top, bottom = 0, 1
+		}
+		r.drawPlayerBar(img, 0, top, ply)
+		r.drawPlayerBar(img, boardRect.Max.Y, bottom, ply)
+	}
+	if r.hasCaptionBar() {
+		y := size.Y - r.barHeight
+		var caption, openingName string
+		if r.Caption && ply > 0 {
+			caption = moveCaption(r.positions[ply-1], r.moves[ply-1])
+		}
+		if r.Opening && r.openings[ply] != nil {
+			openingName = r.openings[ply].Code() + " " + r.openings[ply].Title()
+		}
+		r.drawBar(img, y, caption, colorText, openingName, colorTextMuted)
+	}
+	if r.Players && ply == len(r.moves) && len(r.result) > 0 {
+		y := boardRect.Min.Y + (r.board.Size-r.barHeight)/2
+		draw.Draw(img, image.Rect(0, y, size.X, y+r.barHeight), image.NewUniform(colorBar), image.Point{}, draw.Src)
+		text := r.fit(r.result, size.X)
+		x := (size.X - r.textWidth(text)) / 2
+		drawText(img, x, y+barPadding*r.scale/2, text, colorText, r.scale)
+	}
+	return img
+}
+
+func (r *overlayRenderer) drawPlayerBar(img draw.Image, y, side, ply int) {
+	var name, clock string
+	if r.Players {
+		name = r.players[side]
+	}
+	if r.Clocks {
+		clock = r.clocks[side][ply]
+	}
+	r.drawBar(img, y, name, colorText, clock, colorText)
+}
+
+// drawBar draws a text aligned to the left and another one aligned to the right side of a bar
+func (r *overlayRenderer) drawBar(img draw.Image, y int, left string, leftColor color.Color, right string, rightColor color.Color) {
+	margin := barPadding * r.scale
+	textY := y + margin/2
+	width := r.board.Size - 2*margin
+	if len(left) > 0 {
+		left = r.fit(left, width)
+		drawText(img, margin, textY, left, leftColor, r.scale)
+		width -= r.textWidth(left) + margin
+	}
+	if len(right) > 0 {
+		right = r.fit(right, width)
+		drawText(img, r.board.Size-margin-r.textWidth(right), textY, right, rightColor, r.scale)
+	}
+}
+
+func (r *overlayRenderer) textWidth(s string) int {
+	return utf8.RuneCountInString(s) * glyphWidth * r.scale
+}
+
+// fit truncates a text to the given width
+func (r *overlayRenderer) fit(s string, width int) string {
+	maxLen := width / (glyphWidth * r.scale)
+	if maxLen <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	if maxLen <= 3 {
+		return string(runes[:maxLen])
+	}
+	return string(runes[:maxLen-3]) + "..."
+}
+
+// moveCaption returns the move number and SAN of a move like 12... Nf6
+func moveCaption(pos *chess.Position, move *chess.Move) string {
+	number := strings.Fields(pos.String())[5]
+	dots := "."
+	if pos.Turn() == chess.Black {
+		dots = "..."
+	}
+	return number + dots + " " + chess.AlgebraicNotation{}.Encode(pos, move)
+}
+
+// drawText draws a text with its top left corner at x,y scaled up by an integer factor
+func drawText(dst draw.Image, x, y int, s string, c color.Color, scale int) {
+	src := image.NewRGBA(image.Rect(0, 0, utf8.RuneCountInString(s)*glyphWidth, glyphHeight))
+	d := font.Drawer{
+		Dst:  src,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(0, basicfont.Face7x13.Ascent),
+	}
+	d.DrawString(s)
+	dr := image.Rect(x, y, x+src.Bounds().Dx()*scale, y+glyphHeight*scale)
+	draw.NearestNeighbor.Scale(dst, dr, src, src.Bounds(), draw.Over, nil)
+}
@@ -0,0 +1,20 @@
+package razchess
+
+import (
+	"image/png"
+	"io"
+
+	"github.com/notnil/chess"
+)
+
+// PositionToPNG renders a position (and the move that lead to it, if not nil) as a PNG image
+func PositionToPNG(w io.Writer, pos *chess.Position, move *chess.Move, opts BoardOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	img, err := renderPosition(pos, move, opts)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,221 @@
+package razchess
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/board"
+	"github.com/razzie/razchess/pkg/problem"
+)
+
+const (
+	problemSolveTime  = 20 * time.Second
+	problemDefendTime = 10 * time.Second
+)
+
+// ErrTooManyProblems is returned when too many new problems are being solved at the same time
+var ErrTooManyProblems = errors.New("too many problems are being solved, try again later")
+
+// problemSolvers limits the number of new problems solved at the same time
+var problemSolvers = make(chan struct{}, runtime.NumCPU())
+
+// Problem is the state of a composed problem being solved in a room
+type Problem struct {
+	Stipulation string   `json:"stipulation"`
+	Description string   `json:"description"`
+	Solutions   int      `json:"solutions"`
+	Cooked      bool     `json:"cooked"`
+	MovesLeft   int      `json:"movesLeft"`
+	Mistakes    int      `json:"mistakes"`
+	Message     string   `json:"message,omitempty"`
+	IsOver      bool     `json:"isOver"`
+	Solved      bool     `json:"solved"`
+	Solution    []string `json:"solution,omitempty"` // revealed when the problem is over
+}
+
+type problemMode struct {
+	state     Problem
+	fen       string
+	stip      problem.Stipulation
+	solutions [][]string
+	attacker  chess.Color
+	played    []string // helpmate moves in UCI notation
+}
+
+func newProblem(fen, stipulation string) (*problemMode, error) {
+	stip, err := problem.ParseStipulation(stipulation)
+	if err != nil {
+		return nil, err
+	}
+	b, err := board.FromFEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	pos := chess.NewGame(opt).Position()
+	if pos.Status() != chess.NoMethod {
+		return nil, fmt.Errorf("the problem position is already over")
+	}
+	select {
+	case problemSolvers <- struct{}{}:
+	default:
+		return nil, ErrTooManyProblems
+	}
+	solutions, err := problem.Solve(b, stip, problemSolveTime)
+	<-problemSolvers
+	if err != nil {
+		return nil, err
+	}
+	if len(solutions) == 0 {
+		return nil, fmt.Errorf("the problem has no solution")
+	}
+	return &problemMode{
+		state: Problem{
+			Stipulation: stip.String(),
+			Description: stip.Description(),
+			Solutions:   len(solutions),
+			Cooked:      len(solutions) > 1,
+			MovesLeft:   stip.Moves,
+		},
+		fen:       fen,
+		stip:      stip,
+		solutions: solutions,
+		attacker:  pos.Turn(),
+	}, nil
+}
+
+func (p *problemMode) start(sess *Session) {
+	sess.game = newGameFromFEN(p.fen)
+}
+
+func (p *problemMode) handleMove(sess *Session, move *chess.Move) bool {
+	if p.state.IsOver {
+		return false
+	}
+	if p.stip.Kind == problem.Helpmate {
+		return p.handleHelpmateMove(sess, move)
+	}
+	pos := sess.game.Position()
+	if pos.Turn() != p.attacker {
+		return false
+	}
+	san := chess.AlgebraicNotation{}.Encode(pos, move)
+	b, _ := board.FromFEN(pos.String())
+	m, _ := b.ParseMove(move.String())
+	b.Make(&m)
+
+	if p.stip.Kind == problem.Directmate && b.InCheck() && !b.HasLegalMoves() {
+		sess.handleMove(move)
+		p.finish(true, "Solved: "+san+" is mate")
+		sess.updateClients()
+		return true
+	}
+	defense, refutes, ok, err := problem.Defend(b, problem.Stipulation{Kind: p.stip.Kind, Moves: p.state.MovesLeft}, problemDefendTime)
+	switch {
+	case err != nil:
+		return p.reject(sess, err.Error())
+	case !ok:
+		return p.reject(sess, san+" leaves the opponent without moves")
+	case refutes:
+		return p.reject(sess, fmt.Sprintf("%s is refuted by %s", san, chess.AlgebraicNotation{}.Encode(pos.Update(move), findValidMove(pos.Update(move), defense.String()))))
+	}
+
+	sess.handleMove(move)
+	p.state.MovesLeft--
+	p.state.Message = san + " is correct"
+	sess.updateClients()
+
+	sess.handleMoveStr(defense.String())
+	if p.stip.Kind == problem.Selfmate && sess.game.Position().Status() == chess.Checkmate {
+		p.finish(true, "Solved: the opponent was forced to give mate")
+	}
+	sess.updateClients()
+	return true
+}
+
+func (p *problemMode) handleHelpmateMove(sess *Session, move *chess.Move) bool {
+	pos := sess.game.Position()
+	san := chess.AlgebraicNotation{}.Encode(pos, move)
+	played := append(p.played[:len(p.played):len(p.played)], move.String())
+	if !p.matchesSolution(played) {
+		return p.reject(sess, san+" does not lead to a solution")
+	}
+	sess.handleMove(move)
+	p.played = played
+	p.state.Message = san + " is correct"
+	if len(played)%2 == 0 {
+		p.state.MovesLeft--
+	}
+	if len(played) == p.stip.Moves*2 {
+		p.finish(true, "Solved")
+	}
+	sess.updateClients()
+	return true
+}
+
+func (p *problemMode) handleResign(sess *Session, color chess.Color) {
+	if !p.state.IsOver {
+		p.finish(false, "Gave up")
+	}
+}
+
+func (p *problemMode) decorate(u *Update) {
+	state := p.state
+	u.Problem = &state
+	u.Tablebase = nil
+	if state.IsOver {
+		u.IsGameOver = true
+	}
+	if len(state.Message) > 0 {
+		u.Status = state.Message + " - " + u.Status
+	}
+}
+
+// reject refuses a move and tells the solvers why
+func (p *problemMode) reject(sess *Session, message string) bool {
+	p.state.Mistakes++
+	p.state.Message = message
+	sess.updateClients()
+	return false
+}
+
+func (p *problemMode) matchesSolution(moves []string) bool {
+	for _, solution := range p.solutions {
+		if len(solution) >= len(moves) && strings.Join(solution[:len(moves)], " ") == strings.Join(moves, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *problemMode) finish(solved bool, message string) {
+	p.state.IsOver = true
+	p.state.Solved = solved
+	p.state.Message = message
+	p.state.Solution = make([]string, 0, len(p.solutions))
+	for _, solution := range p.solutions {
+		p.state.Solution = append(p.state.Solution, lineToSAN(p.fen, solution))
+	}
+}
+
+// lineToSAN converts a line of moves in UCI notation to algebraic notation
+func lineToSAN(fen string, line []string) string {
+	pos := newGameFromFEN(fen).Position()
+	san := make([]string, 0, len(line))
+	for _, uci := range line {
+		move := findValidMove(pos, uci)
+		if move == nil {
+			break
+		}
+		san = append(san, chess.AlgebraicNotation{}.Encode(pos, move))
+		pos = pos.Update(move)
+	}
+	return strings.Join(san, " ")
+}
@@ -0,0 +1,226 @@
+package razchess
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/board"
+	"github.com/razzie/razchess/pkg/problem"
+)
+
+const (
+	PuzzleStreak        = "streak"
+	PuzzleRush          = "rush"
+	DefaultRushDuration = 3 * time.Minute
+	MaxRushDuration     = 30 * time.Minute
+	MaxNicknameLength   = 32
+	maxPuzzleMoves      = 2
+	puzzleSolveTime     = 5 * time.Second
+)
+
+type PuzzleResult struct {
+	Puzzle  int     `json:"puzzle"`
+	Solved  bool    `json:"solved"`
+	Seconds float64 `json:"seconds"`
+}
+
+// PuzzleRun is the state of a puzzle streak or rush
+type PuzzleRun struct {
+	Mode     string         `json:"mode"`
+	Nickname string         `json:"nickname,omitempty"`
+	Score    int            `json:"score"`
+	Best     int            `json:"best"`
+	MateIn   int            `json:"mateIn"`
+	Deadline int64          `json:"deadline,omitempty"` // unix milliseconds
+	Results  []PuzzleResult `json:"results"`
+	IsOver   bool           `json:"isOver"`
+}
+
+type puzzleRun struct {
+	state       PuzzleRun
+	scoreMode   string
+	duration    time.Duration
+	puzzles     []string
+	order       []int
+	current     int
+	solver      chess.Color
+	movesLeft   int
+	puzzleStart time.Time
+	timer       *time.Timer
+	pending     *time.Timer // the delayed reply of the defender or the next puzzle
+	scores      *highScores
+}
+
+func newPuzzleRun(mode, nickname string, duration time.Duration, puzzles []string, scores *highScores) (*puzzleRun, error) {
+	if len(puzzles) == 0 {
+		return nil, fmt.Errorf("no puzzles")
+	}
+	if len(nickname) > MaxNicknameLength {
+		return nil, fmt.Errorf("nickname is too long")
+	}
+	run := &puzzleRun{
+		state: PuzzleRun{
+			Mode:     mode,
+			Nickname: nickname,
+			Results:  []PuzzleResult{},
+		},
+		puzzles: puzzles,
+		scores:  scores,
+	}
+	switch mode {
+	case PuzzleStreak:
+		run.scoreMode = PuzzleStreak
+	case PuzzleRush:
+		if duration <= 0 {
+			duration = DefaultRushDuration
+		}
+		if duration > MaxRushDuration {
+			return nil, fmt.Errorf("rush duration is too long: %v", duration)
+		}
+		run.duration = duration.Round(time.Minute)
+		run.scoreMode = fmt.Sprintf("%s-%dm", PuzzleRush, int(run.duration.Minutes()))
+	default:
+		return nil, fmt.Errorf("unknown puzzle mode: %s", mode)
+	}
+	if len(nickname) > 0 {
+		run.state.Best = scores.best(run.scoreMode, nickname)
+	}
+	return run, nil
+}
+
+func (run *puzzleRun) start(sess *Session) {
+	run.order = rand.Perm(len(run.puzzles))
+	if run.duration > 0 {
+		run.state.Deadline = time.Now().Add(run.duration).UnixMilli()
+		run.timer = time.AfterFunc(run.duration, func() {
+			sess.mtx.Lock()
+			defer sess.mtx.Unlock()
+			if !run.state.IsOver {
+				run.finish()
+				sess.updateClients()
+			}
+		})
+	}
+	run.loadNextPuzzle(sess)
+}
+
+func (run *puzzleRun) handleMove(sess *Session, move *chess.Move) bool {
+	if run.state.IsOver || run.pending != nil || sess.game.Position().Turn() != run.solver || !sess.handleMove(move) {
+		return false
+	}
+	sess.updateClients()
+
+	pos := sess.game.Position()
+	if pos.Status() == chess.Checkmate {
+		run.puzzleDone(sess, true)
+		return true
+	}
+	b, _ := board.FromFEN(pos.String())
+	defense, refutes, ok, err := problem.Defend(b, problem.Stipulation{Kind: problem.Directmate, Moves: run.movesLeft}, puzzleSolveTime)
+	if err != nil || refutes || !ok {
+		run.puzzleDone(sess, false)
+		return true
+	}
+
+	run.movesLeft--
+	run.later(sess, time.Second/2, func() {
+		sess.handleMoveStr(defense.String())
+	})
+	return true
+}
+
+// later calls f with the session locked after a delay unless the run is over by then,
+// the solver's moves are rejected until then
+func (run *puzzleRun) later(sess *Session, delay time.Duration, f func()) {
+	run.pending = time.AfterFunc(delay, func() {
+		sess.mtx.Lock()
+		defer sess.mtx.Unlock()
+		run.pending = nil
+		if !run.state.IsOver {
+			f()
+			sess.updateClients()
+		}
+	})
+}
+
+func (run *puzzleRun) handleResign(sess *Session, color chess.Color) {
+	if !run.state.IsOver && run.pending == nil && color == run.solver {
+		run.puzzleDone(sess, false)
+	}
+}
+
+func (run *puzzleRun) decorate(u *Update) {
+	state := run.state
+	u.PuzzleRun = &state
+	u.Tablebase = nil
+	if state.IsOver {
+		u.IsGameOver = true
+		if state.Mode == PuzzleRush {
+			u.Status = fmt.Sprintf("Time is up: %d puzzles solved", state.Score)
+		} else {
+			u.Status = fmt.Sprintf("Streak over: %d puzzles solved", state.Score)
+		}
+	}
+}
+
+func (run *puzzleRun) puzzleDone(sess *Session, solved bool) {
+	run.state.Results = append(run.state.Results, PuzzleResult{
+		Puzzle:  run.current,
+		Solved:  solved,
+		Seconds: time.Since(run.puzzleStart).Round(time.Millisecond).Seconds(),
+	})
+	if solved {
+		run.state.Score++
+	} else if run.state.Mode == PuzzleStreak {
+		run.finish()
+		sess.updateClients()
+		return
+	}
+	run.later(sess, time.Second, func() {
+		run.loadNextPuzzle(sess)
+	})
+}
+
+func (run *puzzleRun) loadNextPuzzle(sess *Session) {
+	for len(run.order) > 0 {
+		puzzleID := run.order[0]
+		run.order = run.order[1:]
+		opts, err := parseGame(run.puzzles[puzzleID])
+		if err != nil {
+			continue
+		}
+		game := chess.NewGame(opts...)
+		b, err := board.FromFEN(game.Position().String())
+		if err != nil {
+			continue
+		}
+		mateIn, _, err := problem.ShortestMate(b, maxPuzzleMoves, puzzleSolveTime)
+		if err != nil || mateIn == 0 {
+			continue
+		}
+		sess.game = game
+		run.current = puzzleID
+		run.solver = game.Position().Turn()
+		run.movesLeft = mateIn
+		run.puzzleStart = time.Now()
+		run.state.MateIn = mateIn
+		return
+	}
+	run.finish() // ran out of puzzles
+}
+
+func (run *puzzleRun) finish() {
+	run.state.IsOver = true
+	run.state.Deadline = 0
+	if run.timer != nil {
+		run.timer.Stop()
+	}
+	if run.pending != nil {
+		run.pending.Stop()
+	}
+	if len(run.state.Nickname) > 0 {
+		run.state.Best = run.scores.submit(run.scoreMode, run.state.Nickname, run.state.Score)
+	}
+}
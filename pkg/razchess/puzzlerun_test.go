@@ -0,0 +1,35 @@
+package razchess
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPuzzleRunNextPuzzle checks that the next puzzle is loaded a second after solving one
+// and that the solver's moves are rejected until then
+func TestPuzzleRunNextPuzzle(t *testing.T) {
+	mgr := NewSessionMgr(nil, time.Hour)
+	puzzle := "6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1" // mate in 1 by Ra8
+	puzzles := []string{puzzle, puzzle}
+	roomID, err := mgr.CreatePuzzleRun(PuzzleRush, "", time.Minute, puzzles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playMoves(t, mgr, roomID, "a1a8")
+	s, _ := mgr.sessions.Load(roomID)
+	sess := s.(*Session)
+	var valid bool
+	if err := sess.Move("g1g2", &valid); err != nil || valid {
+		t.Error("a move was accepted before the next puzzle was loaded")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	run := sess.mode.(*puzzleRun)
+	if run.state.Score != 1 || run.pending != nil {
+		t.Fatalf("score %d, pending %v: expected the puzzle solved and the next one loaded", run.state.Score, run.pending != nil)
+	}
+	if fen := sess.game.Position().String(); fen != puzzle {
+		t.Errorf("the position is %s, expected the next puzzle %s", fen, puzzle)
+	}
+}
@@ -0,0 +1,203 @@
+package razchess
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/engine"
+)
+
+const (
+	RandomAnyEval      = ""
+	RandomBalanced     = "balanced" // the engine evaluates the position close to equal
+	RandomWinning      = "winning"  // the engine evaluates the position as winning for the side to move
+	balancedCentipawns = 150
+	winningCentipawns  = 300
+	randomPlacements   = 1000
+	randomEngineTries  = 20
+	randomMoveTime     = 100 * time.Millisecond
+	randomMaxDepth     = 10
+)
+
+// GenerateRandomPosition places the pieces of a material signature (like KRPvKR) randomly and legally
+// and returns the FEN of the position
+func GenerateRandomPosition(material string, side chess.Color, eval string) (string, error) {
+	white, black, err := parseMaterial(material)
+	if err != nil {
+		return "", err
+	}
+	if side == chess.NoColor {
+		return "", fmt.Errorf("invalid side to move")
+	}
+	switch eval {
+	case RandomAnyEval, RandomBalanced, RandomWinning:
+	default:
+		return "", fmt.Errorf("unknown evaluation requirement: %s", eval)
+	}
+
+	engineTries := 0
+	for i := 0; i < randomPlacements; i++ {
+		fen, ok := placePieces(white, black, side)
+		if !ok {
+			continue
+		}
+		if eval == RandomAnyEval {
+			return fen, nil
+		}
+		if engineTries >= randomEngineTries {
+			break
+		}
+		engineTries++
+		e, err := engine.Analyze(fen, randomMoveTime, randomMaxDepth)
+		if err != nil {
+			continue
+		}
+		score := evalCentipawns(e)
+		if (eval == RandomBalanced && score >= -balancedCentipawns && score <= balancedCentipawns) ||
+			(eval == RandomWinning && score >= winningCentipawns) {
+			return fen, nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate a %s position for %s", eval, material)
+}
+
+// parseMaterial parses a material signature like KRPvKR into the white and black pieces
+func parseMaterial(material string) (white, black []chess.Piece, err error) {
+	sides := strings.Split(strings.ToUpper(material), "V")
+	if len(sides) != 2 {
+		return nil, nil, fmt.Errorf("invalid material signature: %s", material)
+	}
+	for i, color := range []chess.Color{chess.White, chess.Black} {
+		var pieces []chess.Piece
+		kings, pawns := 0, 0
+		for _, c := range sides[i] {
+			var pt chess.PieceType
+			switch c {
+			case 'K':
+				pt = chess.King
+				kings++
+			case 'Q':
+				pt = chess.Queen
+			case 'R':
+				pt = chess.Rook
+			case 'B':
+				pt = chess.Bishop
+			case 'N':
+				pt = chess.Knight
+			case 'P':
+				pt = chess.Pawn
+				pawns++
+			default:
+				return nil, nil, fmt.Errorf("invalid piece in material signature: %c", c)
+			}
+			pieces = append(pieces, chess.NewPiece(pt, color))
+		}
+		if kings != 1 || pawns > 8 || len(pieces) > 16 {
+			return nil, nil, fmt.Errorf("invalid material signature: %s", material)
+		}
+		if color == chess.White {
+			white = pieces
+		} else {
+			black = pieces
+		}
+	}
+	return
+}
+
+// placePieces tries to put the pieces on random squares and tells if the resulting position is valid
+func placePieces(white, black []chess.Piece, side chess.Color) (string, bool) {
+	squares := make(map[chess.Square]chess.Piece)
+	free := rand.Perm(64)
+	for _, piece := range append(append([]chess.Piece{}, white...), black...) {
+		placed := false
+		for i, idx := range free {
+			sq := chess.Square(idx)
+			if piece.Type() == chess.Pawn && (sq.Rank() == chess.Rank1 || sq.Rank() == chess.Rank8) {
+				continue
+			}
+			squares[sq] = piece
+			free = append(free[:i], free[i+1:]...)
+			placed = true
+			break
+		}
+		if !placed {
+			return "", false
+		}
+	}
+	board := chess.NewBoard(squares)
+	if isAttacked(board, board.KingSquare(side.Other()), side) {
+		return "", false
+	}
+	fen := fmt.Sprintf("%s %s - - 0 1", board.String(), side.String())
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return "", false
+	}
+	if chess.NewGame(opt).Position().Status() != chess.NoMethod {
+		return "", false
+	}
+	return fen, true
+}
+
+var (
+	knightOffsets    = [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingOffsets      = [][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	rookDirections   = [][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+	bishopDirections = [][2]int{{1, 1}, {-1, 1}, {-1, -1}, {1, -1}}
+)
+
+// isAttacked tells if a square is attacked by any piece of the given color
+func isAttacked(board *chess.Board, sq chess.Square, by chess.Color) bool {
+	file, rank := int(sq.File()), int(sq.Rank())
+	pieceAt := func(f, r int) chess.Piece {
+		if f < 0 || f > 7 || r < 0 || r > 7 {
+			return chess.NoPiece
+		}
+		return board.Piece(chess.Square(r*8 + f))
+	}
+	is := func(p chess.Piece, types ...chess.PieceType) bool {
+		if p.Color() != by {
+			return false
+		}
+		for _, pt := range types {
+			if p.Type() == pt {
+				return true
+			}
+		}
+		return false
+	}
+	pawnRank := rank - 1
+	if by == chess.Black {
+		pawnRank = rank + 1
+	}
+	if is(pieceAt(file-1, pawnRank), chess.Pawn) || is(pieceAt(file+1, pawnRank), chess.Pawn) {
+		return true
+	}
+	for _, o := range knightOffsets {
+		if is(pieceAt(file+o[0], rank+o[1]), chess.Knight) {
+			return true
+		}
+	}
+	for _, o := range kingOffsets {
+		if is(pieceAt(file+o[0], rank+o[1]), chess.King) {
+			return true
+		}
+	}
+	slide := func(directions [][2]int, types ...chess.PieceType) bool {
+		for _, d := range directions {
+			for f, r := file+d[0], rank+d[1]; f >= 0 && f <= 7 && r >= 0 && r <= 7; f, r = f+d[0], r+d[1] {
+				if p := pieceAt(f, r); p != chess.NoPiece {
+					if is(p, types...) {
+						return true
+					}
+					break
+				}
+			}
+		}
+		return false
+	}
+	return slide(rookDirections, chess.Rook, chess.Queen) || slide(bishopDirections, chess.Bishop, chess.Queen)
+}
@@ -0,0 +1,294 @@
+package razchess
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/chessimage"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	boardSize    = 512
+	minBoardSize = 64
+	maxBoardSize = 1024
+)
+
+// BoardOptions are the options of the rendered board images
+type BoardOptions struct {
+	Size        int  // in pixels (rounded down to a multiple of 8)
+	Inverted    bool // black's side at the bottom
+	Coordinates bool // rank and file labels
+	Theme       *Theme
+}
+
+// DefaultBoardOptions returns the options used when none are given
+func DefaultBoardOptions() BoardOptions {
+	return BoardOptions{Size: boardSize, Coordinates: true, Theme: defaultTheme()}
+}
+
+// ParseBoardOptions reads the board options from URL query parameters:
+// size (pixels), orientation (white or black), coords (0 or 1) and theme
+func ParseBoardOptions(query url.Values) (BoardOptions, error) {
+	opts := DefaultBoardOptions()
+	if err := parseIntParams(query, map[string]*int{"size": &opts.Size}); err != nil {
+		return opts, err
+	}
+	switch query.Get("orientation") {
+	case "", "white", "w":
+	case "black", "b":
+		opts.Inverted = true
+	default:
+		return opts, fmt.Errorf("invalid orientation: %s (expected white or black)", query.Get("orientation"))
+	}
+	switch query.Get("coords") {
+	case "", "1", "true":
+	case "0", "false":
+		opts.Coordinates = false
+	default:
+		return opts, fmt.Errorf("invalid coords: %s (expected 0 or 1)", query.Get("coords"))
+	}
+	theme, err := getTheme(query.Get("theme"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Theme = theme
+	return opts, opts.validate()
+}
+
+// parseIntParams sets the values of the integer parameters present in the query
+func parseIntParams(query url.Values, params map[string]*int) error {
+	for name, value := range params {
+		if v := query.Get(name); len(v) > 0 {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %s", name, v)
+			}
+			*value = i
+		}
+	}
+	return nil
+}
+
+func (o *BoardOptions) validate() error {
+	if o.Size < minBoardSize || o.Size > maxBoardSize {
+		return fmt.Errorf("board size must be between %d and %d pixels", minBoardSize, maxBoardSize)
+	}
+	o.Size -= o.Size % 8
+	if o.Theme == nil {
+		o.Theme = defaultTheme()
+	}
+	return nil
+}
+
+// pieceImageName returns the file name of a piece's image like kl.png (white king) or pd.png (black pawn)
+func pieceImageName(p chess.Piece) string {
+	shade := "l"
+	if p.Color() == chess.Black {
+		shade = "d"
+	}
+	return p.Type().String() + shade + ".png"
+}
+
+func pieceImageNames() []string {
+	var names []string
+	for p := chess.WhiteKing; p <= chess.BlackPawn; p++ {
+		names = append(names, pieceImageName(p))
+	}
+	return names
+}
+
+// renderOptions returns the chessimage options of the board
+func (o *BoardOptions) renderOptions() chessimage.Options {
+	return chessimage.Options{
+		FileSystem: o.Theme.pieces,
+		AssetPath:  o.Theme.assetPath,
+		BoardSize:  o.Size,
+		PieceRatio: 1,
+		Inverted:   o.Inverted,
+	}
+}
+
+// themed tells if the board looks different from the ones chessimage draws (always in its own colors and with
+// rank and file labels)
+func (o *BoardOptions) themed() bool {
+	return !o.Coordinates || !o.Theme.plain()
+}
+
+// prepareMoveRenderer prepares a renderer of a position and highlights the move that lead to it (if not nil)
+func prepareMoveRenderer(pos *chess.Position, move *chess.Move) (*chessimage.Renderer, error) {
+	return newRenderer(pos.Board(), move, checkSquare(pos, move))
+}
+
+// checkSquare returns the square of the king checked by the move that lead to a position (or NoSquare)
+func checkSquare(pos *chess.Position, move *chess.Move) chess.Square {
+	if move != nil && move.HasTag(chess.Check) {
+		return pos.Board().KingSquare(pos.Turn())
+	}
+	return chess.NoSquare
+}
+
+// newRenderer prepares a renderer of the pieces of a board with the last move and the king in check highlighted
+func newRenderer(b *chess.Board, move *chess.Move, check chess.Square) (*chessimage.Renderer, error) {
+	r, err := chessimage.NewRendererFromFEN(b.String() + " w - - 0 1")
+	if err != nil {
+		return nil, err
+	}
+	if move != nil {
+		r.SetLastMove(chessimage.LastMove{
+			From: squareTile(move.S1()),
+			To:   squareTile(move.S2()),
+		})
+	}
+	if check != chess.NoSquare {
+		r.SetCheckTile(squareTile(check))
+	}
+	return r, nil
+}
+
+func squareTile(sq chess.Square) chessimage.Tile {
+	tile, _ := chessimage.TileFromAN(sq.String())
+	return tile
+}
+
+// renderPosition renders a position and highlights the move that lead to it (if not nil)
+func renderPosition(pos *chess.Position, move *chess.Move, opts BoardOptions) (*image.RGBA, error) {
+	return renderPieces(pos.Board(), move, checkSquare(pos, move), opts)
+}
+
+// renderPieces renders the pieces of a board: with chessimage if the board looks the way chessimage draws it,
+// otherwise the piece images are drawn on the board rendered in the theme
+func renderPieces(b *chess.Board, move *chess.Move, check chess.Square, opts BoardOptions) (*image.RGBA, error) {
+	if !opts.themed() {
+		return renderBoardImage(b, move, check, opts)
+	}
+	squareSize := opts.Size / 8
+	pieces, err := getPieceImages(opts.Theme, squareSize)
+	if err != nil {
+		return nil, err
+	}
+	img := renderBoard(move, check, opts)
+	for sq, p := range b.SquareMap() {
+		draw.Draw(img, squareRect(sq, squareSize, opts.Inverted), pieces[p], image.Point{}, draw.Over)
+	}
+	return img, nil
+}
+
+// renderBoardImage renders the pieces of a board with chessimage in its own colors
+func renderBoardImage(b *chess.Board, move *chess.Move, check chess.Square, opts BoardOptions) (*image.RGBA, error) {
+	r, err := newRenderer(b, move, check)
+	if err != nil {
+		return nil, err
+	}
+	img, err := r.Render(opts.renderOptions())
+	if err != nil {
+		return nil, err
+	}
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba, nil
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba, nil
+}
+
+// scaledPieces caches the piece images by theme and square size
+var scaledPieces sync.Map
+
+type scaledPiecesKey struct {
+	theme      *Theme
+	squareSize int
+}
+
+// getPieceImages returns the piece images of a theme scaled to the squares the way chessimage scales them
+func getPieceImages(theme *Theme, squareSize int) (map[chess.Piece]*image.RGBA, error) {
+	key := scaledPiecesKey{theme, squareSize}
+	if images, ok := scaledPieces.Load(key); ok {
+		return images.(map[chess.Piece]*image.RGBA), nil
+	}
+	images := make(map[chess.Piece]*image.RGBA)
+	for p := chess.WhiteKing; p <= chess.BlackPawn; p++ {
+		src, err := theme.pieceImage(p)
+		if err != nil {
+			return nil, err
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, squareSize, squareSize))
+		draw.BiLinear.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+		images[p] = dst
+	}
+	scaledPieces.Store(key, images)
+	return images, nil
+}
+
+// squareRect returns the area of a square on the board image
+func squareRect(sq chess.Square, squareSize int, inverted bool) image.Rectangle {
+	x, y := int(sq.File()), 7-int(sq.Rank())
+	if inverted {
+		x, y = 7-x, 7-y
+	}
+	return image.Rect(x*squareSize, y*squareSize, (x+1)*squareSize, (y+1)*squareSize)
+}
+
+func isLightSquare(sq chess.Square) bool {
+	return (int(sq.File())+int(sq.Rank()))%2 == 1
+}
+
+// squareColor returns the color of a square with the last move and the king in check highlighted
+func squareColor(sq chess.Square, move *chess.Move, checkSquare chess.Square, theme *Theme) color.Color {
+	switch {
+	case sq == checkSquare:
+		return theme.Check
+	case move != nil && (sq == move.S1() || sq == move.S2()):
+		if isLightSquare(sq) {
+			return theme.HighlightLight
+		}
+		return theme.HighlightDark
+	case isLightSquare(sq):
+		return theme.Light
+	default:
+		return theme.Dark
+	}
+}
+
+// renderBoard renders the squares and the coordinates of an empty board in the colors of the theme
+func renderBoard(move *chess.Move, checkSquare chess.Square, opts BoardOptions) *image.RGBA {
+	squareSize := opts.Size / 8
+	img := image.NewRGBA(image.Rect(0, 0, squareSize*8, squareSize*8))
+	for sq := chess.A1; sq <= chess.H8; sq++ {
+		c := squareColor(sq, move, checkSquare, opts.Theme)
+		draw.Draw(img, squareRect(sq, squareSize, opts.Inverted), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	if opts.Coordinates {
+		drawCoordinates(img, squareSize, opts.Inverted, opts.Theme)
+	}
+	return img
+}
+
+// drawCoordinates labels the files on the bottom and the ranks on the right edge of the board
+func drawCoordinates(img *image.RGBA, squareSize int, inverted bool, theme *Theme) {
+	size := squareSize * 8
+	d := font.Drawer{Dst: img, Face: basicfont.Face7x13}
+	for i := 0; i < 8; i++ {
+		file, rank := i, 7-i
+		if inverted {
+			file, rank = 7-i, i
+		}
+		// the labels have the color of the other kind of square
+		d.Src = image.NewUniform(theme.Light)
+		if i%2 == 1 {
+			d.Src = image.NewUniform(theme.Dark)
+		}
+		d.Dot = fixed.P(squareSize*i+2, size-3)
+		d.DrawString(string(rune('a' + file)))
+		d.Dot = fixed.P(size-10, squareSize*i+12)
+		d.DrawString(string(rune('1' + rank)))
+	}
+}
@@ -0,0 +1,197 @@
+package razchess
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+var (
+	tagPairRegex    = regexp.MustCompile(`^\[\s*(\w+)\s+"(.*)"\s*\]$`)
+	moveNumberRegex = regexp.MustCompile(`^\d+\.+`)
+)
+
+// repertoireNode is a position of a repertoire tree reached by a move
+type repertoireNode struct {
+	parent   *repertoireNode
+	move     *chess.Move
+	pos      *chess.Position
+	line     string // moves leading here in UCI notation
+	children []*repertoireNode
+}
+
+// repertoire is a tree of moves built from a PGN including its variations
+type repertoire struct {
+	root  *repertoireNode
+	lines int
+}
+
+func parseRepertoire(pgn string) (*repertoire, error) {
+	start := chess.NewGame().Position()
+	rep := &repertoire{
+		root: &repertoireNode{pos: start},
+	}
+	node := rep.root
+	var stack []*repertoireNode
+	for _, token := range tokenizePGN(pgn) {
+		switch {
+		case strings.HasPrefix(token, "["):
+			node, stack = rep.root, nil
+			if tag := tagPairRegex.FindStringSubmatch(token); tag != nil && tag[1] == "FEN" {
+				if err := rep.setStart(tag[2]); err != nil {
+					return nil, err
+				}
+				node = rep.root
+			}
+		case token == "(":
+			if node.parent == nil {
+				return nil, fmt.Errorf("variation without a preceding move")
+			}
+			stack = append(stack, node)
+			node = node.parent
+		case token == ")":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unbalanced variation")
+			}
+			node = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case token == "1-0" || token == "0-1" || token == "1/2-1/2" || token == "*":
+			node, stack = rep.root, nil
+		case strings.HasPrefix(token, "$"):
+			// numeric annotation glyph
+		default:
+			san := strings.TrimLeft(moveNumberRegex.ReplaceAllString(token, ""), ".")
+			if len(san) == 0 {
+				continue
+			}
+			if strings.HasPrefix(san, "0-0") {
+				san = strings.ReplaceAll(san, "0", "O")
+			}
+			move, err := chess.AlgebraicNotation{}.Decode(node.pos, san)
+			if err != nil {
+				return nil, err
+			}
+			node = node.addChild(move)
+		}
+	}
+	if len(rep.root.children) == 0 {
+		return nil, fmt.Errorf("empty repertoire")
+	}
+	rep.lines = len(rep.root.leaves())
+	return rep, nil
+}
+
+func (rep *repertoire) setStart(fen string) error {
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		return err
+	}
+	pos := chess.NewGame(opt).Position()
+	if pos.String() == rep.root.pos.String() {
+		return nil
+	}
+	if len(rep.root.children) > 0 {
+		return fmt.Errorf("all games of a repertoire must start from the same position")
+	}
+	rep.root.pos = pos
+	return nil
+}
+
+func (node *repertoireNode) addChild(move *chess.Move) *repertoireNode {
+	if child := node.findChild(move); child != nil {
+		return child
+	}
+	uci := chess.UCINotation{}.Encode(node.pos, move)
+	child := &repertoireNode{
+		parent: node,
+		move:   move,
+		pos:    node.pos.Update(move),
+		line:   strings.TrimSpace(node.line + " " + uci),
+	}
+	node.children = append(node.children, child)
+	return child
+}
+
+func (node *repertoireNode) findChild(move *chess.Move) *repertoireNode {
+	for _, child := range node.children {
+		if child.move.S1() == move.S1() && child.move.S2() == move.S2() && child.move.Promo() == move.Promo() {
+			return child
+		}
+	}
+	return nil
+}
+
+func (node *repertoireNode) leaves() (leaves []*repertoireNode) {
+	if len(node.children) == 0 {
+		return []*repertoireNode{node}
+	}
+	for _, child := range node.children {
+		leaves = append(leaves, child.leaves()...)
+	}
+	return
+}
+
+// hasMovesFor tells if the given color has a move to play in the subtree
+func (node *repertoireNode) hasMovesFor(color chess.Color) bool {
+	if len(node.children) > 0 && node.pos.Turn() == color {
+		return true
+	}
+	for _, child := range node.children {
+		if child.hasMovesFor(color) {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *repertoireNode) san() string {
+	return chess.AlgebraicNotation{}.Encode(node.parent.pos, node.move)
+}
+
+// tokenizePGN splits PGN text into tag pairs, parentheses and movetext words while dropping comments
+func tokenizePGN(pgn string) (tokens []string) {
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for i := 0; i < len(pgn); i++ {
+		switch c := pgn[i]; c {
+		case '{':
+			flush()
+			if end := strings.IndexByte(pgn[i:], '}'); end >= 0 {
+				i += end
+			} else {
+				i = len(pgn)
+			}
+		case ';':
+			flush()
+			if end := strings.IndexByte(pgn[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(pgn)
+			}
+		case '[':
+			flush()
+			end := strings.IndexByte(pgn[i:], ']')
+			if end < 0 {
+				end = len(pgn) - i - 1
+			}
+			tokens = append(tokens, pgn[i:i+end+1])
+			i += end
+		case '(', ')':
+			flush()
+			tokens = append(tokens, string(c))
+		case ' ', '\t', '\r', '\n':
+			flush()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return
+}
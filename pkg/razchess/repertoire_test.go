@@ -0,0 +1,59 @@
+package razchess
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseRepertoire(t *testing.T) {
+	tests := []struct {
+		name  string
+		pgn   string
+		lines []string // of the leaves in UCI notation, sorted
+	}{
+		{"variation", "1. e4 e5 (1... c5 2. Nf3) 2. Nf3 *",
+			[]string{"e2e4 c7c5 g1f3", "e2e4 e7e5 g1f3"}},
+		{"nested variations", "1. d4 d5 (1... Nf6 2. c4 (2. Nf3 g6) e6) 2. c4 *",
+			[]string{"d2d4 d7d5 c2c4", "d2d4 g8f6 c2c4 e7e6", "d2d4 g8f6 g1f3 g7g6"}},
+		{"merged games", "[Event \"a\"]\n\n1. e4 e5 *\n\n[Event \"b\"]\n\n1. e4 c5 *",
+			[]string{"e2e4 c7c5", "e2e4 e7e5"}},
+		{"comments and castling with zeros", "1. e4 {main} e5 $1 2. Nf3 Nc6 3. Bc4 Bc5 4. 0-0 *",
+			[]string{"e2e4 e7e5 g1f3 b8c6 f1c4 f8c5 e1g1"}},
+		{"FEN", "[FEN \"4k3/8/8/8/8/8/4P3/4K3 w - - 0 1\"]\n\n1. e4 Kd7 *",
+			[]string{"e2e4 e8d7"}},
+	}
+	for _, test := range tests {
+		rep, err := parseRepertoire(test.pgn)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var lines []string
+		for _, leaf := range rep.root.leaves() {
+			lines = append(lines, leaf.line)
+		}
+		sort.Strings(lines)
+		if !reflect.DeepEqual(lines, test.lines) || rep.lines != len(test.lines) {
+			t.Errorf("%s: got %d lines %q, expected %q", test.name, rep.lines, lines, test.lines)
+		}
+	}
+}
+
+func TestParseRepertoireErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		pgn  string
+	}{
+		{"empty", "*"},
+		{"illegal move", "1. e5 *"},
+		{"variation without a move", "(1. e4) *"},
+		{"unbalanced variation", "1. e4 e5) *"},
+		{"different starting positions", "1. e4 *\n\n[FEN \"4k3/8/8/8/8/8/4P3/4K3 w - - 0 1\"]\n\n1. e4 *"},
+	}
+	for _, test := range tests {
+		if _, err := parseRepertoire(test.pgn); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
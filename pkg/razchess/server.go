@@ -1,15 +1,23 @@
 package razchess
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
+	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/razchess/pkg/gamedb"
 )
 
 func init() {
@@ -18,12 +26,19 @@ func init() {
 
 type Server struct {
 	http.ServeMux
-	mgr    *SessionMgr
-	index  *template.Template
-	create *template.Template
+	mgr     *SessionMgr
+	index   *template.Template
+	create  *template.Template
+	trainer *template.Template
+	guess   *template.Template
+	drill   *template.Template
+	problem *template.Template
+	gamedb  *template.Template
+	gameset *template.Template
+	games   *template.Template
 }
 
-func NewServer(assets fs.FS, mgr *SessionMgr, puzzles []string) *Server {
+func NewServer(assets fs.FS, mgr *SessionMgr, puzzles, games []string) *Server {
 	indexRaw, err := fs.ReadFile(assets, "index.html")
 	if err != nil {
 		panic(err)
@@ -32,15 +47,52 @@ func NewServer(assets fs.FS, mgr *SessionMgr, puzzles []string) *Server {
 	if err != nil {
 		panic(err)
 	}
+	trainerRaw, err := fs.ReadFile(assets, "trainer.html")
+	if err != nil {
+		panic(err)
+	}
+	guessRaw, err := fs.ReadFile(assets, "guess.html")
+	if err != nil {
+		panic(err)
+	}
+	drillRaw, err := fs.ReadFile(assets, "drill.html")
+	if err != nil {
+		panic(err)
+	}
+	problemRaw, err := fs.ReadFile(assets, "problem.html")
+	if err != nil {
+		panic(err)
+	}
+	gamedbRaw, err := fs.ReadFile(assets, "gamedb.html")
+	if err != nil {
+		panic(err)
+	}
+	gamesetRaw, err := fs.ReadFile(assets, "gameset.html")
+	if err != nil {
+		panic(err)
+	}
+	gamesRaw, err := fs.ReadFile(assets, "games.html")
+	if err != nil {
+		panic(err)
+	}
 	srv := &Server{
-		mgr:    mgr,
-		index:  template.Must(template.New("").Parse(string(indexRaw))),
-		create: template.Must(template.New("").Parse(string(createRaw))),
+		mgr:     mgr,
+		index:   template.Must(template.New("").Parse(string(indexRaw))),
+		create:  template.Must(template.New("").Parse(string(createRaw))),
+		trainer: template.Must(template.New("").Parse(string(trainerRaw))),
+		guess:   template.Must(template.New("").Parse(string(guessRaw))),
+		drill:   template.Must(template.New("").Parse(string(drillRaw))),
+		problem: template.Must(template.New("").Parse(string(problemRaw))),
+		gamedb:  template.Must(template.New("").Parse(string(gamedbRaw))),
+		gameset: template.Must(template.New("").Parse(string(gamesetRaw))),
+		games:   template.Must(template.New("").Parse(string(gamesRaw))),
 	}
 
-	if len(puzzles) == 0 {
+	if puzzles = verifyPuzzles(puzzles); len(puzzles) == 0 {
 		puzzles = GetInternalPuzzles()
 	}
+	daily := newDailyPuzzles(puzzles)
+	daily.prepare(time.Now(), DailyPuzzleFeedDays)
 
 	srv.Handle("/img/", http.FileServer(http.FS(assets)))
 	srv.Handle("/css/", http.FileServer(http.FS(assets)))
@@ -58,12 +110,19 @@ func NewServer(assets fs.FS, mgr *SessionMgr, puzzles []string) *Server {
 		if len(roomID) == 0 {
 			srv.redirectToNewSession(w, r)
 		}
-		srv.index.Execute(w, roomID)
+		srv.index.Execute(w, srv.newIndexPage(r, roomID))
 	})
 
 	srv.HandleFunc("/create", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
-			r.ParseForm()
+			if err := parseCreateForm(w, r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if games := SplitPGN(r.Form.Get("pgn")); !r.Form.Has("fen") && len(games) > 1 {
+				srv.serveNewGameSet(w, r, games)
+				return
+			}
 			game, err := gameFromForm(r.Form)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -80,11 +139,125 @@ func NewServer(assets fs.FS, mgr *SessionMgr, puzzles []string) *Server {
 		srv.create.Execute(w, game)
 	})
 
+	srv.HandleFunc("/set/", func(w http.ResponseWriter, r *http.Request) {
+		setID, number, _ := strings.Cut(r.URL.Path[5:], "/")
+		if len(number) == 0 {
+			srv.serveGameSet(w, r, setID)
+			return
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			http.Error(w, "invalid game number: "+number, http.StatusBadRequest)
+			return
+		}
+		roomID, err := srv.mgr.OpenGameSetGame(setID, n)
+		if err != nil {
+			if errors.Is(err, ErrGameSetNotFound) {
+				http.Error(w, "Game set not found", http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
+	})
+
+	srv.HandleFunc("/trainer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			r.ParseForm()
+			srv.serveTrainer(w, r, r.Form)
+		} else {
+			srv.trainer.Execute(w, nil)
+		}
+	})
+
+	srv.HandleFunc("/guess", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			r.ParseForm()
+			srv.serveGuessTheMove(w, r, r.Form, games)
+		} else {
+			srv.guess.Execute(w, len(games))
+		}
+	})
+
 	srv.HandleFunc("/puzzle", func(w http.ResponseWriter, r *http.Request) {
 		puzzleID := rand.Intn(len(puzzles))
 		http.Redirect(w, r, "/puzzle/"+fmt.Sprint(puzzleID), http.StatusTemporaryRedirect)
 	})
 
+	srv.HandleFunc("/puzzle/streak", func(w http.ResponseWriter, r *http.Request) {
+		srv.servePuzzleRun(w, r, PuzzleStreak, puzzles)
+	})
+
+	srv.HandleFunc("/puzzle/rush", func(w http.ResponseWriter, r *http.Request) {
+		srv.servePuzzleRun(w, r, PuzzleRush, puzzles)
+	})
+
+	srv.HandleFunc("/puzzle/highscores", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, mgr.HighScores(r.URL.Query().Get("mode")))
+	})
+
+	srv.HandleFunc("/puzzle/daily", func(w http.ResponseWriter, r *http.Request) {
+		dp, err := daily.get(time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		srv.serveSession(w, r, dp.FEN, false)
+	})
+
+	srv.HandleFunc("/puzzle/daily.json", func(w http.ResponseWriter, r *http.Request) {
+		date := time.Now()
+		if query := r.URL.Query().Get("date"); len(query) > 0 {
+			var err error
+			if date, err = ParseDailyPuzzleDate(query); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		dp, err := daily.get(date)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, dp)
+	})
+
+	srv.HandleFunc("/puzzle/daily.atom", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		if err := daily.writeFeed(w, getBaseURL(r), time.Now(), DailyPuzzleFeedDays); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	srv.HandleFunc("/puzzle/daily/", func(w http.ResponseWriter, r *http.Request) {
+		date := r.URL.Path[14:]
+		isImage := strings.HasSuffix(date, ".png")
+		t, err := ParseDailyPuzzleDate(strings.TrimSuffix(date, ".png"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		dp, err := daily.get(t)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if isImage {
+			opts, err := parseGame(dp.FEN)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			if err := PositionToPNG(w, chess.NewGame(opts...).Position(), nil, DefaultBoardOptions()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		} else {
+			srv.serveSession(w, r, dp.FEN, false)
+		}
+	})
+
 	srv.HandleFunc("/puzzle/", func(w http.ResponseWriter, r *http.Request) {
 		puzzleID, err := strconv.Atoi(r.URL.Path[8:])
 		if err != nil || puzzleID < 0 || puzzleID >= len(puzzles) {
@@ -93,22 +266,188 @@ func NewServer(assets fs.FS, mgr *SessionMgr, puzzles []string) *Server {
 		srv.serveSession(w, r, puzzles[puzzleID], false)
 	})
 
+	srv.HandleFunc("/drill", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			r.ParseForm()
+			moves, _ := strconv.Atoi(r.Form.Get("moves"))
+			srv.serveDrill(w, r, DrillSetup{
+				FEN:   strings.TrimSpace(r.Form.Get("fen")),
+				Goal:  r.Form.Get("goal"),
+				Moves: moves,
+			})
+		} else {
+			srv.drill.Execute(w, Drills())
+		}
+	})
+
+	srv.HandleFunc("/drill/", func(w http.ResponseWriter, r *http.Request) {
+		setup, ok := drills[r.URL.Path[7:]]
+		if !ok {
+			http.Redirect(w, r, "/drill", http.StatusTemporaryRedirect)
+			return
+		}
+		srv.serveDrill(w, r, *setup)
+	})
+
+	srv.HandleFunc("/problem", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			r.ParseForm()
+			srv.serveProblem(w, r, strings.TrimSpace(r.Form.Get("fen")), r.Form.Get("stipulation"))
+		} else {
+			srv.problem.Execute(w, nil)
+		}
+	})
+
+	srv.HandleFunc("/gamedb", func(w http.ResponseWriter, r *http.Request) {
+		srv.gamedb.Execute(w, SearchGameDB(gameDBQuery(r.URL.Query())))
+	})
+
+	srv.HandleFunc("/gamedb.json", func(w http.ResponseWriter, r *http.Request) {
+		search := SearchGameDB(gameDBQuery(r.URL.Query()))
+		if len(search.Error) > 0 {
+			http.Error(w, search.Error, http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, search.Results)
+	})
+
+	srv.HandleFunc("/gamedb/open/", func(w http.ResponseWriter, r *http.Request) {
+		var id, ply int
+		if _, err := fmt.Sscanf(r.URL.Path[13:], "%d/%d", &id, &ply); err != nil {
+			http.Redirect(w, r, "/gamedb", http.StatusTemporaryRedirect)
+			return
+		}
+		game, err := gameDBGameAt(id, ply)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		srv.serveSession(w, r, game, true)
+	})
+
+	srv.HandleFunc("/games", func(w http.ResponseWriter, r *http.Request) {
+		srv.games.Execute(w, srv.mgr.SearchArchive(archiveQuery(r.URL.Query())))
+	})
+
+	srv.HandleFunc("/games.json", func(w http.ResponseWriter, r *http.Request) {
+		search := srv.mgr.SearchArchive(archiveQuery(r.URL.Query()))
+		if len(search.Error) > 0 {
+			http.Error(w, search.Error, http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, search)
+	})
+
+	srv.HandleFunc("/games/open/", func(w http.ResponseWriter, r *http.Request) {
+		roomID, err := srv.mgr.ReopenArchivedGame(r.URL.Path[12:])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
+	})
+
+	srv.HandleFunc("/games/pgn/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path[11:]
+		game, err := srv.mgr.ArchivedGame(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename="+id+".pgn")
+		w.Header().Set("Content-Type", ExportFormats["pgn"].ContentType)
+		io.WriteString(w, game.PGN+"\n")
+	})
+
+	srv.HandleFunc("/random", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		side := chess.White
+		if query.Get("side") == "b" {
+			side = chess.Black
+		}
+		fen, err := GenerateRandomPosition(query.Get("material"), side, query.Get("eval"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		srv.serveSession(w, r, fen, true)
+	})
+
 	srv.HandleFunc("/fischer-random", func(w http.ResponseWriter, r *http.Request) {
 		srv.serveSession(w, r, GenerateFischerRandomFEN(), true)
 	})
 
+	srv.HandleFunc("/explorer.json", func(w http.ResponseWriter, r *http.Request) {
+		fen := r.URL.Query().Get("fen")
+		if len(fen) == 0 {
+			fen = StartingFEN
+		}
+		pos, err := ExplorePosition(fen)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, pos)
+	})
+
 	srv.HandleFunc("/ws/", func(w http.ResponseWriter, r *http.Request) {
 		roomID := r.URL.Path[4:]
 		mgr.ServeRPC(w, r, roomID)
 	})
 
-	srv.HandleFunc("/gif/", func(w http.ResponseWriter, r *http.Request) {
-		roomID := r.URL.Path[5:]
-		w.Header().Set("Content-Disposition", "attachment; filename="+roomID+".gif")
-		w.Header().Set("Content-Type", "image/gif")
-		if err := mgr.MoveHistoryToGIF(w, roomID); err != nil {
-			http.Error(w, "Session not found", http.StatusNotFound)
+	srv.HandleFunc("/png/", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveSnapshot(w, r, r.URL.Path[5:], "png")
+	})
+
+	srv.HandleFunc("/svg/", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveSnapshot(w, r, r.URL.Path[5:], "svg")
+	})
+
+	srv.HandleFunc("/img", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		opts, err := ParseBoardOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fen := query.Get("fen")
+		if len(fen) == 0 {
+			fen = StartingFEN
 		}
+		opt, err := chess.FEN(fen)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSnapshot(w, chess.NewGame(opt).Position(), nil, query.Get("format"), opts)
+	})
+
+	srv.HandleFunc("/render/gif", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveRender(w, r, "gif")
+	})
+
+	srv.HandleFunc("/render/apng", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveRender(w, r, "apng")
+	})
+
+	srv.HandleFunc("/render/png", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveRender(w, r, "png")
+	})
+
+	srv.HandleFunc("/render/svg", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveRender(w, r, "svg")
+	})
+
+	srv.HandleFunc("/gif/", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveAnimation(w, r, r.URL.Path[5:], "gif")
+	})
+
+	srv.HandleFunc("/apng/", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveAnimation(w, r, r.URL.Path[6:], "apng")
+	})
+
+	srv.HandleFunc("/export/", func(w http.ResponseWriter, r *http.Request) {
+		srv.serveExport(w, r, r.URL.Path[8:])
 	})
 
 	return srv
@@ -121,14 +460,397 @@ func (srv *Server) serveSession(w http.ResponseWriter, r *http.Request, game str
 	} else if showRoomID {
 		http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
 	} else {
-		srv.index.Execute(w, roomID)
+		srv.index.Execute(w, srv.newIndexPage(r, roomID))
 	}
 }
 
+func (srv *Server) serveNewGameSet(w http.ResponseWriter, r *http.Request, games []string) {
+	setID, err := srv.mgr.CreateGameSet(games)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/set/"+setID, http.StatusSeeOther) // no need to post the games again
+}
+
+func (srv *Server) serveGameSet(w http.ResponseWriter, r *http.Request, setID string) {
+	set, err := srv.mgr.GameSet(setID)
+	if err != nil {
+		http.Error(w, "Game set not found", http.StatusNotFound)
+		return
+	}
+	srv.gameset.Execute(w, set)
+}
+
+func (srv *Server) servePuzzleRun(w http.ResponseWriter, r *http.Request, mode string, puzzles []string) {
+	query := r.URL.Query()
+	nickname := strings.TrimSpace(query.Get("nickname"))
+	var duration time.Duration
+	if minutes, err := strconv.Atoi(query.Get("minutes")); err == nil {
+		duration = time.Duration(minutes) * time.Minute
+	}
+	roomID, err := srv.mgr.CreatePuzzleRun(mode, nickname, duration, puzzles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
+}
+
+func (srv *Server) serveTrainer(w http.ResponseWriter, r *http.Request, form url.Values) {
+	color := chess.White
+	if form.Get("color") == "b" {
+		color = chess.Black
+	}
+	nickname := strings.TrimSpace(form.Get("nickname"))
+	roomID, err := srv.mgr.CreateTrainer(form.Get("pgn"), color, form.Get("choice"), nickname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
+}
+
+func (srv *Server) serveGuessTheMove(w http.ResponseWriter, r *http.Request, form url.Values, games []string) {
+	pgn := strings.TrimSpace(form.Get("pgn"))
+	if len(pgn) == 0 {
+		if len(games) == 0 {
+			http.Error(w, "no PGN given and there are no games to choose from", http.StatusBadRequest)
+			return
+		}
+		pgn = games[rand.Intn(len(games))]
+	} else if uploaded := SplitPGN(pgn); len(uploaded) > 1 {
+		pgn = uploaded[rand.Intn(len(uploaded))]
+	}
+	var color chess.Color
+	switch form.Get("color") {
+	case "w":
+		color = chess.White
+	case "b":
+		color = chess.Black
+	}
+	fromMove, _ := strconv.Atoi(form.Get("from"))
+	roomID, err := srv.mgr.CreateGuessTheMove(pgn, color, fromMove, form.Get("engine") == "on")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
+}
+
+func (srv *Server) serveDrill(w http.ResponseWriter, r *http.Request, setup DrillSetup) {
+	roomID, err := srv.mgr.CreateDrill(setup)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
+}
+
+func (srv *Server) serveProblem(w http.ResponseWriter, r *http.Request, fen, stipulation string) {
+	roomID, err := srv.mgr.CreateProblem(fen, stipulation)
+	if err != nil {
+		if errors.Is(err, ErrTooManyProblems) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	http.Redirect(w, r, "/room/"+roomID, http.StatusTemporaryRedirect)
+}
+
+// serveSnapshot serves an image of a session's position (the current one or the one after the ply query parameter)
+func (srv *Server) serveSnapshot(w http.ResponseWriter, r *http.Request, roomID, format string) {
+	query := r.URL.Query()
+	opts, err := ParseBoardOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ply, err := parsePly(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pos, move, err := srv.mgr.PositionAt(roomID, ply)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	writeSnapshot(w, pos, move, format, opts)
+}
+
+var animationContentTypes = map[string]string{
+	"gif":  "image/gif",
+	"apng": "image/apng",
+}
+
+// serveAnimation serves the move history of a session as an animated GIF or PNG
+func (srv *Server) serveAnimation(w http.ResponseWriter, r *http.Request, roomID, format string) {
+	opts, err := ParseGIFOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ext := format
+	if format == "apng" {
+		ext = "png"
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+roomID+"."+ext)
+	w.Header().Set("Content-Type", animationContentTypes[format])
+	err = streamResponse(w, r, func(w io.Writer) error {
+		if format == "apng" {
+			return srv.mgr.MoveHistoryToAPNG(w, roomID, opts)
+		}
+		return srv.mgr.MoveHistoryToGIF(w, roomID, opts)
+	})
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		if errors.Is(err, ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}
+}
+
+// streamWriter tells if a response has started
+type streamWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+// streamResponse streams a response written by write and returns its error if nothing was written yet.
+// After the first byte the error can't be reported anymore: it is logged and the connection is aborted,
+// so the client doesn't take an error message for the end of the file.
+func streamResponse(w http.ResponseWriter, r *http.Request, write func(io.Writer) error) error {
+	sw := &streamWriter{ResponseWriter: w}
+	err := write(sw)
+	if err != nil && sw.started {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		panic(http.ErrAbortHandler)
+	}
+	return err
+}
+
+// serveExport serves the game of a session in the format query parameter (PGN by default) as a file
+func (srv *Server) serveExport(w http.ResponseWriter, r *http.Request, roomID string) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = "pgn"
+	}
+	f, ok := ExportFormats[format]
+	if !ok {
+		http.Error(w, "invalid format: "+format+" (expected pgn, epd, json or uci)", http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	if err := srv.mgr.ExportGame(&buf, roomID, format, getBaseURL(r)+"/room/"+roomID); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+roomID+"."+f.Extension)
+	w.Header().Set("Content-Type", f.ContentType)
+	buf.WriteTo(w)
+}
+
+// parsePly returns the ply query parameter or -1 (the current position) if it's missing
+func parsePly(query url.Values) (int, error) {
+	v := query.Get("ply")
+	if len(v) == 0 {
+		return -1, nil
+	}
+	ply, err := strconv.Atoi(v)
+	if err != nil || ply < 0 {
+		return 0, fmt.Errorf("invalid ply: %s", v)
+	}
+	return ply, nil
+}
+
+// size limit of the PGN files uploaded to create games
+const maxUploadSize = 10 << 20
+
+// limits of the stateless render API
+const (
+	maxRenderBodySize = 1 << 20
+	maxRenderPlies    = 1000
+)
+
+// serveRender renders a game posted as PGN or FEN (either as the request body or as a pgn or fen form field)
+func (srv *Server) serveRender(w http.ResponseWriter, r *http.Request, format string) {
+	if r.Method != "POST" {
+		http.Error(w, "POST a PGN or FEN", http.StatusMethodNotAllowed)
+		return
+	}
+	game, err := gameFromRenderRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	switch format {
+	case "gif", "apng":
+		opts, err := ParseGIFOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", animationContentTypes[format])
+		err = streamResponse(w, r, func(w io.Writer) error {
+			if format == "apng" {
+				return MoveHistoryToAPNG(w, game, opts)
+			}
+			return MoveHistoryToGIF(w, game, opts)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	default:
+		opts, err := ParseBoardOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ply, err := parsePly(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pos, move, err := positionAt(game.Moves(), game.Positions(), ply)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSnapshot(w, pos, move, format, opts)
+	}
+}
+
+// gameFromRenderRequest parses the game of a render request with the same rules as the created rooms
+func gameFromRenderRequest(w http.ResponseWriter, r *http.Request) (*chess.Game, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRenderBodySize)
+	var game string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxRenderBodySize); err != nil {
+			return nil, err
+		}
+		var err error
+		if game, err = gameFromForm(r.PostForm); err != nil {
+			return nil, err
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		// clients like curl send raw bodies as form data too
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			game, _ = gameFromForm(form)
+		}
+		if len(game) == 0 {
+			game = strings.TrimSpace(string(body))
+			if _, err := chess.FEN(game); err == nil {
+				game = "fen:" + game
+			} else {
+				game = "pgn:" + game
+			}
+		}
+	}
+	opts, err := parseGame(game)
+	if err != nil {
+		return nil, err
+	}
+	g := chess.NewGame(opts...)
+	if plies := len(g.Moves()); plies > maxRenderPlies {
+		return nil, fmt.Errorf("the game is too long: %d plies (at most %d are allowed)", plies, maxRenderPlies)
+	}
+	return g, nil
+}
+
+// writeSnapshot writes an image of a position in PNG or SVG format
+func writeSnapshot(w http.ResponseWriter, pos *chess.Position, move *chess.Move, format string, opts BoardOptions) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "", "png":
+		w.Header().Set("Content-Type", "image/png")
+		err = PositionToPNG(&buf, pos, move, opts)
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = PositionToSVG(&buf, pos, move, opts)
+	default:
+		http.Error(w, "invalid format: "+format+" (expected png or svg)", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+// indexPage is the data of the room page, the Open Graph tags make shared room links show the current position
+type indexPage struct {
+	RoomID   string
+	URL      string
+	ImageURL string
+	GameSet  *GameSetLink // if the room was opened from a game set
+}
+
+func (srv *Server) newIndexPage(r *http.Request, roomID string) *indexPage {
+	baseURL := getBaseURL(r)
+	page := &indexPage{
+		RoomID:   roomID,
+		URL:      baseURL + "/room/" + roomID,
+		ImageURL: baseURL + "/png/" + roomID,
+	}
+	if link, ok := srv.mgr.GameSetOf(roomID); ok {
+		page.GameSet = &link
+	}
+	return page
+}
+
 func (srv *Server) redirectToNewSession(w http.ResponseWriter, r *http.Request) {
 	srv.serveSession(w, r, "", true)
 }
 
+// parseCreateForm parses the form of a created game, an uploaded PGN file (which may contain several games)
+// takes the place of the pgn field
+func parseCreateForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return err
+	}
+	file, _, err := r.FormFile("pgnfile")
+	if err != nil {
+		return nil // no file uploaded
+	}
+	defer file.Close()
+	pgn, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(pgn)) > 0 {
+		r.Form.Set("pgn", string(pgn))
+	}
+	return nil
+}
+
 func gameFromForm(form url.Values) (string, error) {
 	for _, gameType := range []string{"fen", "pgn"} {
 		if form.Has(gameType) {
@@ -137,3 +859,40 @@ func gameFromForm(form url.Values) (string, error) {
 	}
 	return "", fmt.Errorf("invalid form")
 }
+
+func archiveQuery(form url.Values) ArchiveQuery {
+	return ArchiveQuery{
+		Player:  form.Get("player"),
+		Result:  form.Get("result"),
+		Opening: form.Get("opening"),
+		From:    form.Get("from"),
+		To:      form.Get("to"),
+	}
+}
+
+func gameDBQuery(form url.Values) gamedb.Query {
+	return gamedb.Query{
+		FEN:      form.Get("fen"),
+		Material: form.Get("material"),
+		Pawns:    form.Get("pawns"),
+		Player:   form.Get("player"),
+		Event:    form.Get("event"),
+		ECO:      form.Get("eco"),
+		Result:   form.Get("result"),
+	}
+}
+
+func getBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -1,19 +1,36 @@
 package razchess
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/notnil/chess"
 	"github.com/razzie/jsonrpc"
+	"github.com/razzie/razchess/pkg/explorer"
 	"golang.org/x/net/websocket"
 )
 
+// gameMode lets special room types (like puzzle runs) take over how a session reacts to its players.
+// All methods are called with the session's mutex locked.
+type gameMode interface {
+	start(sess *Session)
+	handleMove(sess *Session, move *chess.Move) bool
+	handleResign(sess *Session, color chess.Color)
+	decorate(u *Update)
+}
+
 type Session struct {
-	slc     *sessionLifecycle
-	mtx     sync.Mutex
-	game    *chess.Game
-	clients []*jsonrpc.JsonRPC
+	slc       *sessionLifecycle
+	mtx       sync.Mutex
+	game      *chess.Game
+	mode      gameMode
+	clients   []*jsonrpc.JsonRPC
+	explored  bool   // the finished game was added to the explorer and the archive
+	archiveID string // of the finished game
+	created   time.Time
 }
 
 func newSession(slc *sessionLifecycle, game string) (*Session, error) {
@@ -24,6 +41,19 @@ func newSession(slc *sessionLifecycle, game string) (*Session, error) {
 	return sess, nil
 }
 
+func newModeSession(slc *sessionLifecycle, mode gameMode) *Session {
+	sess := &Session{
+		slc:     slc,
+		game:    chess.NewGame(),
+		mode:    mode,
+		created: time.Now(),
+	}
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	mode.start(sess)
+	return sess
+}
+
 func (sess *Session) init(slc *sessionLifecycle, game string) error {
 	opts, err := parseGame(game)
 	if err != nil {
@@ -31,6 +61,7 @@ func (sess *Session) init(slc *sessionLifecycle, game string) error {
 	}
 	sess.slc = slc
 	sess.game = chess.NewGame(opts...)
+	sess.created = time.Now()
 	return nil
 }
 
@@ -39,6 +70,12 @@ func (sess *Session) Move(move string, validMove *bool) error {
 	sess.mtx.Lock()
 	defer sess.mtx.Unlock()
 
+	if sess.mode != nil {
+		m := findValidMove(sess.game.Position(), move)
+		*validMove = m != nil && sess.mode.handleMove(sess, m)
+		return nil
+	}
+
 	*validMove = sess.handleMoveStr(move)
 	if !*validMove {
 		return nil
@@ -55,7 +92,8 @@ func (sess *Session) Move(move string, validMove *bool) error {
 		sess.updateClients()
 	}
 
-	go sess.slc.update(gameToString(sess.game))
+	sess.checkFinished()
+	go sess.slc.save(sess)
 
 	return nil
 }
@@ -69,20 +107,107 @@ func (sess *Session) Resign(color string, unused *bool) error {
 		return nil
 	}
 
+	var c chess.Color
 	switch color {
 	case "w":
-		sess.game.Resign(chess.White)
+		c = chess.White
 	case "b":
-		sess.game.Resign(chess.Black)
+		c = chess.Black
 	default:
 		return nil
 	}
 
+	if sess.mode != nil {
+		sess.mode.handleResign(sess, c)
+	} else {
+		sess.game.Resign(c)
+		sess.checkFinished()
+	}
+
 	sess.updateClients()
 
 	return nil
 }
 
+// Session.Explore is an RPC function that returns the moves played in a position (the current one if fen is empty)
+func (sess *Session) Explore(fen string, result *explorer.Position) error {
+	if len(fen) == 0 {
+		sess.mtx.Lock()
+		fen = sess.game.Position().String()
+		sess.mtx.Unlock()
+	}
+	pos, err := ExplorePosition(fen)
+	if err != nil {
+		return err
+	}
+	*result = *pos
+	return nil
+}
+
+// Session.SetTags is an RPC function that sets tag pairs of the game like White, Black or Event
+// (an empty value removes the tag)
+func (sess *Session) SetTags(tags map[string]string, unused *bool) error {
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+
+	if sess.mode != nil {
+		return fmt.Errorf("the tags of this game can't be edited")
+	}
+
+	setGameTags(sess.game, tags)
+	if len(sess.archiveID) > 0 {
+		sess.slc.mgr.archive.update(sess.archiveID, copyGame(sess.game), sess.created)
+	}
+	sess.updateClients()
+	go sess.slc.save(sess)
+
+	return nil
+}
+
+// Session.GetTags is an RPC function that returns the tag pairs of the game
+func (sess *Session) GetTags(unused bool, tags *map[string]string) error {
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	*tags = gameTags(sess.game)
+	return nil
+}
+
+// checkFinished adds the game to the explorer and the archive once it's over
+func (sess *Session) checkFinished() {
+	if sess.explored || sess.game.Outcome() == chess.NoOutcome {
+		return
+	}
+	sess.explored = true
+	exploreGame(sess.game)
+	sess.archiveID = sess.slc.mgr.archive.add(sess.slc.roomID, copyGame(sess.game), sess.created)
+}
+
+// archiveUnfinished archives the game when the session expires before it's over, the rooms of the game modes
+// (like puzzle runs and drills) aren't archived
+func (sess *Session) archiveUnfinished() {
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	if sess.mode != nil || sess.explored || sess.game.Outcome() != chess.NoOutcome || len(sess.game.Moves()) == 0 {
+		return
+	}
+	sess.explored = true
+	sess.archiveID = sess.slc.mgr.archive.add(sess.slc.roomID, copyGame(sess.game), sess.created)
+}
+
+// record returns the stored form of the session, it's called with the session's mutex locked
+func (sess *Session) record() string {
+	data, _ := json.Marshal(sessionRecord{
+		Game:      gameToString(sess.game),
+		Created:   sess.created,
+		ArchiveID: sess.archiveID,
+	})
+	return string(data)
+}
+
 func (sess *Session) handleMove(move *chess.Move) bool {
 	if err := sess.game.Move(move); err != nil {
 		return false
@@ -98,12 +223,43 @@ func (sess *Session) handleMoveStr(moveStr string) bool {
 	return sess.handleMove(move)
 }
 
+// findValidMove returns the valid move of the position that matches the UCI notation
+func findValidMove(pos *chess.Position, moveStr string) *chess.Move {
+	move, err := chess.UCINotation{}.Decode(pos, moveStr)
+	if err != nil {
+		return nil
+	}
+	for _, m := range pos.ValidMoves() {
+		if m.S1() == move.S1() && m.S2() == move.S2() && m.Promo() == move.Promo() {
+			return m
+		}
+	}
+	return nil
+}
+
 func (sess *Session) getMoveHistory() ([]*chess.Move, []*chess.Position) {
 	sess.mtx.Lock()
 	defer sess.mtx.Unlock()
 	return sess.game.Moves(), sess.game.Positions()
 }
 
+// cloneGame returns a copy of the game including the move comments
+func (sess *Session) cloneGame() *chess.Game {
+	sess.mtx.Lock()
+	defer sess.mtx.Unlock()
+	return copyGame(sess.game)
+}
+
+// copyGame returns a copy of a game including the move comments, which are dropped by Clone
+func copyGame(game *chess.Game) *chess.Game {
+	if len(game.Comments()) > 0 {
+		if opt, err := chess.PGN(strings.NewReader(game.String())); err == nil {
+			return chess.NewGame(opt)
+		}
+	}
+	return game.Clone()
+}
+
 func (sess *Session) addClient(client *jsonrpc.JsonRPC) {
 	sess.mtx.Lock()
 	defer sess.mtx.Unlock()
@@ -129,12 +285,20 @@ func (sess *Session) removeClient(client *jsonrpc.JsonRPC) {
 	sess.updateViewCounts()
 }
 
+func (sess *Session) newUpdate() *Update {
+	u := newUpdate(sess.game)
+	if sess.mode != nil {
+		sess.mode.decorate(u)
+	}
+	return u
+}
+
 func (sess *Session) updateClient(client *jsonrpc.JsonRPC, update *Update) {
 	client.Notify("Session.Update", update)
 }
 
 func (sess *Session) updateClients() {
-	update := newUpdate(sess.game)
+	update := sess.newUpdate()
 	for _, client := range sess.clients {
 		sess.updateClient(client, update)
 	}
@@ -153,7 +317,10 @@ func (sess *Session) serve(ws *websocket.Conn) {
 
 	sess.addClient(client)
 
-	sess.updateClient(client, newUpdate(sess.game))
+	sess.mtx.Lock()
+	update := sess.newUpdate()
+	sess.mtx.Unlock()
+	sess.updateClient(client, update)
 	client.Serve()
 
 	sess.removeClient(client)
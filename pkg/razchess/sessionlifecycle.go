@@ -1,6 +1,7 @@
 package razchess
 
 import (
+	"sync"
 	"time"
 )
 
@@ -9,6 +10,8 @@ type sessionLifecycle struct {
 	roomID      string
 	killTimer   *time.Timer
 	killTimeout time.Duration
+	saveMtx     sync.Mutex // keeps the saves and the deletion of the stored session in order
+	deleted     bool
 }
 
 func newSessionLifecycle(mgr *SessionMgr, roomID string) *sessionLifecycle {
@@ -29,8 +32,26 @@ func (slc *sessionLifecycle) resetRoomID(roomID string) {
 	slc.roomID = roomID
 }
 
-func (slc *sessionLifecycle) update(game string) {
-	slc.mgr.updateSession(slc.roomID, game)
+// save stores the session as it is at the time of the save, so an earlier save never overwrites a later one
+// and nothing is saved once the session is deleted
+func (slc *sessionLifecycle) save(sess *Session) {
+	slc.saveMtx.Lock()
+	defer slc.saveMtx.Unlock()
+	if slc.deleted {
+		return
+	}
+	sess.mtx.Lock()
+	record := sess.record()
+	sess.mtx.Unlock()
+	slc.mgr.updateSession(slc.roomID, record)
+}
+
+// delete deletes the stored session
+func (slc *sessionLifecycle) delete() {
+	slc.saveMtx.Lock()
+	defer slc.saveMtx.Unlock()
+	slc.deleted = true
+	slc.mgr.deleteSession(slc.roomID)
 }
 
 func (slc *sessionLifecycle) startTimer() {
@@ -1,6 +1,8 @@
 package razchess
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,33 +11,41 @@ import (
 	"sync"
 	"time"
 
+	"github.com/notnil/chess"
 	"golang.org/x/net/websocket"
 )
 
 const DefaultKillTimeout = time.Hour
 
+// ErrSessionNotFound is returned for room IDs without a session
+var ErrSessionNotFound = errors.New("session not found")
+
 type SessionMgr struct {
 	killTimeout time.Duration
 	sessions    sync.Map
-	db          *DB
+	sets        sync.Map // game sets by ID
+	setRooms    sync.Map // game set links by room ID
+	db          Storage
+	scores      *highScores
+	reps        *repetitions
+	archive     *archive
 }
 
-func NewSessionMgr(redisURL string, killTimeout time.Duration) *SessionMgr {
+// NewSessionMgr creates a session manager, the sessions and records are only kept in memory if db is nil
+func NewSessionMgr(db Storage, killTimeout time.Duration) *SessionMgr {
 	if killTimeout == 0 {
 		killTimeout = DefaultKillTimeout
 	}
 	mgr := &SessionMgr{
 		killTimeout: killTimeout,
+		db:          db,
 	}
-	if len(redisURL) > 0 {
-		db, err := NewDB(redisURL)
-		if err != nil {
-			log.Println("Redis error:", err)
-		} else {
-			mgr.db = db
-			mgr.loadSessions()
-		}
+	if db != nil {
+		mgr.loadSessions()
 	}
+	mgr.scores = newHighScores(mgr.db)
+	mgr.reps = newRepetitions(mgr.db)
+	mgr.archive = newArchive(mgr.db)
 	return mgr
 }
 
@@ -45,19 +55,69 @@ func (mgr *SessionMgr) CreateSession(game string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	for {
-		roomID := GenerateID(6)
-		if _, loaded := mgr.sessions.LoadOrStore(roomID, sess); !loaded {
-			slc.resetRoomID(roomID)
-			if len(game) > 0 {
-				log.Printf("[new custom session: %s] %s", roomID, strings.NewReplacer("\n", " ", "\r", "").Replace(game))
-			} else {
-				log.Printf("[new session: %s]", roomID)
-			}
-			go slc.update(gameToString(sess.game))
-			return roomID, nil
-		}
+	roomID := mgr.storeSession(sess)
+	if len(game) > 0 {
+		log.Printf("[new custom session: %s] %s", roomID, strings.NewReplacer("\n", " ", "\r", "").Replace(game))
+	} else {
+		log.Printf("[new session: %s]", roomID)
+	}
+	go slc.save(sess)
+	return roomID, nil
+}
+
+func (mgr *SessionMgr) CreatePuzzleRun(mode, nickname string, duration time.Duration, puzzles []string) (string, error) {
+	run, err := newPuzzleRun(mode, nickname, duration, puzzles, mgr.scores)
+	if err != nil {
+		return "", err
+	}
+	roomID := mgr.createModeSession(run)
+	log.Printf("[new puzzle %s session: %s] %s", mode, roomID, nickname)
+	return roomID, nil
+}
+
+func (mgr *SessionMgr) CreateTrainer(pgn string, color chess.Color, choice, nickname string) (string, error) {
+	t, err := newTrainer(pgn, color, choice, nickname, mgr.reps)
+	if err != nil {
+		return "", err
+	}
+	roomID := mgr.createModeSession(t)
+	log.Printf("[new opening trainer session: %s] %d lines, %s", roomID, t.rep.lines, nickname)
+	return roomID, nil
+}
+
+func (mgr *SessionMgr) CreateGuessTheMove(pgn string, color chess.Color, fromMove int, useEngine bool) (string, error) {
+	g, err := newGuessTheMove(pgn, color, fromMove, useEngine)
+	if err != nil {
+		return "", err
+	}
+	roomID := mgr.createModeSession(g)
+	log.Printf("[new guess the move session: %s] %s - %s", roomID, g.state.White, g.state.Black)
+	return roomID, nil
+}
+
+func (mgr *SessionMgr) CreateDrill(setup DrillSetup) (string, error) {
+	d, err := newDrill(setup)
+	if err != nil {
+		return "", err
 	}
+	roomID := mgr.createModeSession(d)
+	log.Printf("[new drill session: %s] %s - %s", roomID, d.state.Title, d.state.FEN)
+	return roomID, nil
+}
+
+func (mgr *SessionMgr) CreateProblem(fen, stipulation string) (string, error) {
+	p, err := newProblem(fen, stipulation)
+	if err != nil {
+		return "", err
+	}
+	roomID := mgr.createModeSession(p)
+	log.Printf("[new problem session: %s] %s - %s", roomID, p.state.Stipulation, fen)
+	return roomID, nil
+}
+
+// HighScores returns the puzzle run high scores of a mode (or all modes if empty)
+func (mgr *SessionMgr) HighScores(mode string) []*HighScore {
+	return mgr.scores.ranking(mode)
 }
 
 func (mgr *SessionMgr) ServeRPC(w http.ResponseWriter, r *http.Request, roomID string) {
@@ -69,13 +129,73 @@ func (mgr *SessionMgr) ServeRPC(w http.ResponseWriter, r *http.Request, roomID s
 	websocket.Handler(sess.serve).ServeHTTP(w, r)
 }
 
-func (mgr *SessionMgr) MoveHistoryToGIF(w io.Writer, roomID string) error {
+func (mgr *SessionMgr) MoveHistoryToGIF(w io.Writer, roomID string, opts GIFOptions) error {
 	sess, ok := mgr.sessions.Load(roomID)
 	if !ok {
-		return fmt.Errorf("session not found: %s", roomID)
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, roomID)
+	}
+	return MoveHistoryToGIF(w, sess.(*Session).cloneGame(), opts)
+}
+
+func (mgr *SessionMgr) MoveHistoryToAPNG(w io.Writer, roomID string, opts GIFOptions) error {
+	sess, ok := mgr.sessions.Load(roomID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, roomID)
+	}
+	return MoveHistoryToAPNG(w, sess.(*Session).cloneGame(), opts)
+}
+
+// ExportGame writes the game of a session in one of the ExportFormats with the tags filled from the session data,
+// site is the URL of the room
+func (mgr *SessionMgr) ExportGame(w io.Writer, roomID, format, site string) error {
+	sess, ok := mgr.sessions.Load(roomID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, roomID)
+	}
+	game := withExportTags(sess.(*Session).cloneGame(), site, sess.(*Session).created)
+	return exportGame(w, game, format)
+}
+
+// PositionAt returns the position of a session after the given ply (or the current one if ply is negative)
+// and the move that lead to it
+func (mgr *SessionMgr) PositionAt(roomID string, ply int) (*chess.Position, *chess.Move, error) {
+	sess, ok := mgr.sessions.Load(roomID)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrSessionNotFound, roomID)
 	}
 	moves, positions := sess.(*Session).getMoveHistory()
-	return MoveHistoryToGIF(w, moves, positions)
+	return positionAt(moves, positions, ply)
+}
+
+// positionAt returns the position after the given ply (or the last one if ply is negative) and the move that lead to it
+func positionAt(moves []*chess.Move, positions []*chess.Position, ply int) (*chess.Position, *chess.Move, error) {
+	if ply < 0 {
+		ply = len(moves)
+	}
+	if ply > len(moves) {
+		return nil, nil, fmt.Errorf("invalid ply: %d (the game has %d plies)", ply, len(moves))
+	}
+	if ply == 0 {
+		return positions[0], nil, nil
+	}
+	return positions[ply], moves[ply-1], nil
+}
+
+// createModeSession creates a room of a game mode, these rooms are only kept in memory (the state of the modes
+// isn't stored, so they don't survive a restart)
+func (mgr *SessionMgr) createModeSession(mode gameMode) string {
+	sess := newModeSession(newSessionLifecycle(mgr, ""), mode)
+	return mgr.storeSession(sess)
+}
+
+func (mgr *SessionMgr) storeSession(sess *Session) string {
+	for {
+		roomID := GenerateID(6)
+		if _, loaded := mgr.sessions.LoadOrStore(roomID, sess); !loaded {
+			sess.slc.resetRoomID(roomID)
+			return roomID
+		}
+	}
 }
 
 func (mgr *SessionMgr) getOrCreateSession(roomID string) *Session {
@@ -87,25 +207,59 @@ func (mgr *SessionMgr) getOrCreateSession(roomID string) *Session {
 	return sess.(*Session)
 }
 
+// sessionRecord is the stored form of a session
+type sessionRecord struct {
+	Game      string    `json:"game"`
+	Created   time.Time `json:"created"`
+	ArchiveID string    `json:"archive,omitempty"` // of the finished game
+}
+
+// parseSessionRecord parses a stored session, the older records only contain the game
+func parseSessionRecord(record string) sessionRecord {
+	var rec sessionRecord
+	if !strings.HasPrefix(record, "{") || json.Unmarshal([]byte(record), &rec) != nil {
+		return sessionRecord{Game: record}
+	}
+	return rec
+}
+
 func (mgr *SessionMgr) loadSessions() {
-	for roomID, game := range mgr.db.LoadSessions() {
+	for roomID, record := range mgr.db.LoadSessions() {
 		log.Printf("[Loading session from persistent storage: %s]", roomID)
-		sess, err := newSession(newSessionLifecycle(mgr, roomID), game)
+		rec := parseSessionRecord(record)
+		sess, err := newSession(newSessionLifecycle(mgr, roomID), rec.Game)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
+		if !rec.Created.IsZero() {
+			sess.created = rec.Created
+		}
+		sess.archiveID = rec.ArchiveID
+		sess.explored = len(rec.ArchiveID) > 0
 		mgr.sessions.Store(roomID, sess)
 	}
 }
 
-func (mgr *SessionMgr) updateSession(roomID, game string) {
+func (mgr *SessionMgr) updateSession(roomID, record string) {
 	if mgr.db != nil && len(roomID) > 0 {
-		mgr.db.SaveSession(roomID, game, mgr.killTimeout)
+		mgr.db.SaveSession(roomID, record, mgr.killTimeout)
+	}
+}
+
+func (mgr *SessionMgr) deleteSession(roomID string) {
+	if mgr.db != nil && len(roomID) > 0 {
+		mgr.db.DeleteSession(roomID)
 	}
 }
 
 func (mgr *SessionMgr) killSession(roomID string) {
 	log.Printf("[session expired: %s]", roomID)
-	mgr.sessions.Delete(roomID)
+	if v, ok := mgr.sessions.Load(roomID); ok {
+		sess := v.(*Session)
+		sess.archiveUnfinished()
+		sess.slc.delete() // before the room is released, so the deletion can't follow the saves of a new session
+		mgr.sessions.Delete(roomID)
+	}
+	mgr.setRooms.Delete(roomID)
 }
@@ -0,0 +1,36 @@
+package razchess
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSessionStorage checks that the stored sessions keep their creation time and that a save after the deletion
+// doesn't bring an expired session back
+func TestSessionStorage(t *testing.T) {
+	db := newMemStorage()
+	mgr := NewSessionMgr(db, time.Hour)
+	roomID, err := mgr.CreateSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	playMoves(t, mgr, roomID, "e2e4", "e7e5")
+	v, _ := mgr.sessions.Load(roomID)
+	sess := v.(*Session)
+	sess.slc.save(sess)
+
+	loaded := NewSessionMgr(db, time.Hour)
+	v, ok := loaded.sessions.Load(roomID)
+	if !ok {
+		t.Fatalf("the session wasn't stored")
+	}
+	if l := v.(*Session); !l.created.Equal(sess.created) || len(l.game.Moves()) != 2 {
+		t.Errorf("loaded a session created at %v with %d moves, expected %v with 2 moves", l.created, len(l.game.Moves()), sess.created)
+	}
+
+	mgr.killSession(roomID)
+	sess.slc.save(sess) // like a save that was late
+	if _, ok := db.LoadSessions()[roomID]; ok {
+		t.Errorf("the expired session is still stored")
+	}
+}
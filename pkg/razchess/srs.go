@@ -0,0 +1,126 @@
+package razchess
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	repertoireKeyPrefix = "repertoire:"
+	minLineEase         = 1.3
+	defaultLineEase     = 2.5
+)
+
+// lineProgress is the spaced repetition state of a repertoire line (a simplified SM-2)
+type lineProgress struct {
+	Reps     int       `json:"reps"`
+	Lapses   int       `json:"lapses"`
+	Interval int       `json:"interval"` // days
+	Ease     float64   `json:"ease"`
+	Due      time.Time `json:"due"`
+}
+
+func (p *lineProgress) grade(success bool, now time.Time) {
+	if p.Ease == 0 {
+		p.Ease = defaultLineEase
+	}
+	if success {
+		p.Reps++
+		switch p.Reps {
+		case 1:
+			p.Interval = 1
+		case 2:
+			p.Interval = 3
+		default:
+			p.Interval = int(math.Round(float64(p.Interval) * p.Ease))
+		}
+		p.Ease += 0.1
+	} else {
+		p.Reps = 0
+		p.Lapses++
+		p.Interval = 0
+		p.Ease = math.Max(minLineEase, p.Ease-0.2)
+	}
+	p.Due = now.AddDate(0, 0, p.Interval)
+}
+
+// weight tells how urgently a line should be trained (unseen and overdue lines come first)
+func (p *lineProgress) weight(now time.Time) float64 {
+	if p == nil {
+		return 2
+	}
+	if overdue := now.Sub(p.Due); overdue >= 0 {
+		return 1 + overdue.Hours()/24
+	}
+	return 0.1
+}
+
+func (p *lineProgress) isDue(now time.Time) bool {
+	return p == nil || !now.Before(p.Due)
+}
+
+// repetitions stores the repertoire line progress of each nickname
+type repetitions struct {
+	mtx      sync.Mutex
+	saveMtx  sync.Mutex // keeps the saves in order
+	db       Storage
+	progress map[string]map[string]*lineProgress // nickname -> line -> progress
+}
+
+func newRepetitions(db Storage) *repetitions {
+	return &repetitions{
+		db:       db,
+		progress: make(map[string]map[string]*lineProgress),
+	}
+}
+
+// get returns a copy of the line progress of a nickname
+func (r *repetitions) get(nickname string) map[string]*lineProgress {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	lines := make(map[string]*lineProgress)
+	for line, p := range r.load(nickname) {
+		progress := *p
+		lines[line] = &progress
+	}
+	return lines
+}
+
+func (r *repetitions) set(nickname, line string, p lineProgress) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	lines := r.load(nickname)
+	lines[line] = &p
+	if r.db != nil {
+		go r.save(nickname)
+	}
+}
+
+// save saves the current progress of a nickname, so a save that finishes later never writes an older snapshot
+func (r *repetitions) save(nickname string) {
+	r.saveMtx.Lock()
+	defer r.saveMtx.Unlock()
+	r.mtx.Lock()
+	data, _ := json.Marshal(r.progress[nickname])
+	r.mtx.Unlock()
+	r.db.SaveRecord(repertoireKeyPrefix+nickname, string(data))
+}
+
+func (r *repetitions) load(nickname string) map[string]*lineProgress {
+	if lines, ok := r.progress[nickname]; ok {
+		return lines
+	}
+	lines := make(map[string]*lineProgress)
+	if r.db != nil {
+		if data, ok := r.db.LoadRecord(repertoireKeyPrefix + nickname); ok {
+			if err := json.Unmarshal([]byte(data), &lines); err != nil {
+				log.Println("failed to load repertoire progress:", err)
+			}
+		}
+	}
+	r.progress[nickname] = lines
+	return lines
+}
@@ -0,0 +1,43 @@
+package razchess
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLineProgressGrade(t *testing.T) {
+	now := time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		success  bool
+		reps     int
+		lapses   int
+		interval int
+		ease     float64
+	}{
+		{true, 1, 0, 1, 2.6},
+		{true, 2, 0, 3, 2.7},
+		{true, 3, 0, 8, 2.8}, // 3 * 2.7
+		{false, 0, 1, 0, 2.6},
+		{true, 1, 1, 1, 2.7},
+		{false, 0, 2, 0, 2.5},
+	}
+	var p lineProgress
+	for i, test := range tests {
+		p.grade(test.success, now)
+		if p.Reps != test.reps || p.Lapses != test.lapses || p.Interval != test.interval ||
+			p.Ease < test.ease-1e-9 || p.Ease > test.ease+1e-9 || !p.Due.Equal(now.AddDate(0, 0, test.interval)) {
+			t.Errorf("grade %d: got %+v, expected reps %d, lapses %d, interval %d, ease %.1f",
+				i+1, p, test.reps, test.lapses, test.interval, test.ease)
+		}
+	}
+}
+
+func TestLineProgressMinEase(t *testing.T) {
+	var p lineProgress
+	for i := 0; i < 10; i++ {
+		p.grade(false, time.Now())
+	}
+	if p.Ease != minLineEase {
+		t.Errorf("the ease is %v after the failures, expected %v", p.Ease, minLineEase)
+	}
+}
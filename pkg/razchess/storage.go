@@ -0,0 +1,33 @@
+package razchess
+
+import (
+	"strings"
+	"time"
+)
+
+// Storage persists the sessions and the other records (like high scores) of the server
+type Storage interface {
+	// LoadSessions returns the sessions that haven't expired yet by room ID
+	LoadSessions() map[string]string
+	// SaveSession stores a session (its game and creation time) until it expires
+	SaveSession(room, session string, expiration time.Duration)
+	DeleteSession(room string)
+	LoadRecord(key string) (string, bool)
+	SaveRecord(key, value string)
+}
+
+// OpenStorage opens a Redis storage for redis:// and rediss:// URLs, otherwise a file storage in the given directory
+func OpenStorage(location string) (Storage, error) {
+	if strings.HasPrefix(location, "redis://") || strings.HasPrefix(location, "rediss://") {
+		db, err := NewDB(location)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+	fs, err := NewFileStorage(location)
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
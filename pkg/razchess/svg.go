@@ -0,0 +1,143 @@
+package razchess
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+
+	"github.com/notnil/chess"
+)
+
+// svgPiece is the drawing of a piece in a 45x45 box: outlined shapes filled with the piece's color
+// and detail lines in the other color
+type svgPiece struct {
+	paths   []string
+	circles [][3]float64 // x, y and radius
+	details string
+}
+
+const svgPieceSize = 45
+
+var svgPieces = map[chess.PieceType]svgPiece{
+	chess.King: {
+		paths: []string{
+			"M 21,3 H 24 V 6 H 27 V 9 H 24 V 13 H 21 V 9 H 18 V 6 H 21 Z",
+			"M 22.5,13 C 12,13 7,19 10,25 C 11.5,28 14,30 14,30 H 31 C 31,30 33.5,28 35,25 C 38,19 33,13 22.5,13 Z",
+			"M 14,30 H 31 V 33 H 14 Z",
+			"M 10,33 H 35 V 38 H 10 Z",
+		},
+		details: "M 22.5,17 V 27 M 15,22 C 18,25 27,25 30,22",
+	},
+	chess.Queen: {
+		paths: []string{
+			"M 9,15 L 15.5,11 L 18,24 L 22.5,9.5 L 27,24 L 29.5,11 L 36,15 L 31,30 H 14 Z",
+			"M 14,30 H 31 V 33 H 14 Z",
+			"M 10,33 H 35 V 38 H 10 Z",
+		},
+		circles: [][3]float64{{9, 13, 2}, {15.5, 9, 2}, {22.5, 7.5, 2}, {29.5, 9, 2}, {36, 13, 2}},
+		details: "M 15,27 C 19,25.5 26,25.5 30,27",
+	},
+	chess.Rook: {
+		paths: []string{
+			"M 12,9 H 16 V 12 H 20 V 9 H 25 V 12 H 29 V 9 H 33 V 16 H 12 Z",
+			"M 15,16 H 30 L 29,31 H 16 Z",
+			"M 12,31 H 33 V 35 H 12 Z",
+			"M 10,35 H 35 V 39 H 10 Z",
+		},
+		details: "M 16,20 H 29 M 16,27 H 29",
+	},
+	chess.Bishop: {
+		paths: []string{
+			"M 22.5,10.5 C 16,14 14,20 16,26 H 29 C 31,20 29,14 22.5,10.5 Z",
+			"M 15,26 H 30 V 29 H 15 Z",
+			"M 14,29 H 31 L 33,35 H 12 Z",
+			"M 9,35 H 36 V 39 H 9 Z",
+		},
+		circles: [][3]float64{{22.5, 8, 2.5}},
+		details: "M 22.5,15 V 22 M 19,18.5 H 26",
+	},
+	chess.Knight: {
+		paths: []string{
+			"M 18,6 L 21,10 C 29,10 35,16 34,26 L 34,35 H 13 C 13,30 17,27 20,24 C 20,22 19,21 17,22 " +
+				"L 11,25 C 9,25 7,23 8,21 L 15,13 L 16,9 Z",
+			"M 10,35 H 36 V 39 H 10 Z",
+		},
+		details: "M 15.5,16 H 16 M 24,13 C 29,16 31,21 31,28",
+	},
+	chess.Pawn: {
+		paths: []string{
+			"M 19.5,18 H 25.5 L 28,26 L 31,35 H 14 L 17,26 Z",
+			"M 11,35 H 34 V 39 H 11 Z",
+		},
+		circles: [][3]float64{{22.5, 13, 5}},
+	},
+}
+
+// PositionToSVG renders a position (and the move that lead to it, if not nil) as a vector image
+func PositionToSVG(w io.Writer, pos *chess.Position, move *chess.Move, opts BoardOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	squareSize := opts.Size / 8
+	size := squareSize * 8
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", size, size, size, size)
+
+	checkSquare := chess.NoSquare
+	if move != nil && move.HasTag(chess.Check) {
+		checkSquare = pos.Board().KingSquare(pos.Turn())
+	}
+	for sq := chess.A1; sq <= chess.H8; sq++ {
+		c := squareColor(sq, move, checkSquare, opts.Theme)
+		r := squareRect(sq, squareSize, opts.Inverted)
+		fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", r.Min.X, r.Min.Y, squareSize, squareSize, svgColor(c))
+	}
+
+	if opts.Coordinates {
+		fmt.Fprintf(bw, `<g font-family="monospace" font-size="%d">`+"\n", 13)
+		for i := 0; i < 8; i++ {
+			file, rank := i, 7-i
+			if opts.Inverted {
+				file, rank = 7-i, i
+			}
+			c := opts.Theme.Light
+			if i%2 == 1 {
+				c = opts.Theme.Dark
+			}
+			fmt.Fprintf(bw, `<text x="%d" y="%d" fill="%s">%c</text>`+"\n", squareSize*i+2, size-3, svgColor(c), 'a'+file)
+			fmt.Fprintf(bw, `<text x="%d" y="%d" fill="%s">%c</text>`+"\n", size-10, squareSize*i+12, svgColor(c), '1'+rank)
+		}
+		fmt.Fprintln(bw, `</g>`)
+	}
+
+	scale := float64(squareSize) / svgPieceSize
+	for sq, p := range pos.Board().SquareMap() {
+		r := squareRect(sq, squareSize, opts.Inverted)
+		fill, stroke := "#fff", "#000"
+		if p.Color() == chess.Black {
+			fill, stroke = "#000", "#fff"
+		}
+		piece := svgPieces[p.Type()]
+		fmt.Fprintf(bw, `<g transform="translate(%d %d) scale(%g)" fill="%s" stroke="#000" stroke-width="1.5" stroke-linejoin="round" stroke-linecap="round">`+"\n",
+			r.Min.X, r.Min.Y, scale, fill)
+		for _, path := range piece.paths {
+			fmt.Fprintf(bw, `<path d="%s"/>`+"\n", path)
+		}
+		for _, c := range piece.circles {
+			fmt.Fprintf(bw, `<circle cx="%g" cy="%g" r="%g"/>`+"\n", c[0], c[1], c[2])
+		}
+		if len(piece.details) > 0 {
+			fmt.Fprintf(bw, `<path d="%s" fill="none" stroke="%s"/>`+"\n", piece.details, stroke)
+		}
+		fmt.Fprintln(bw, `</g>`)
+	}
+
+	fmt.Fprintln(bw, `</svg>`)
+	return bw.Flush()
+}
+
+func svgColor(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
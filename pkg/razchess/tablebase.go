@@ -0,0 +1,118 @@
+package razchess
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/razzie/razchess/pkg/engine"
+	"github.com/razzie/razchess/pkg/tablebase"
+)
+
+var endgameTables *tablebase.Tablebase
+
+// TablebaseEval is the tablebase value and the best move of a position
+type TablebaseEval struct {
+	*tablebase.Result
+	BestMove string `json:"bestMove,omitempty"` // UCI notation
+	Text     string `json:"text"`
+}
+
+// EnableTablebase enables the endgame tablebase (generated on demand and cached in dir)
+func EnableTablebase(dir string) error {
+	t, err := tablebase.Open(dir)
+	if err != nil {
+		return err
+	}
+	endgameTables = t
+	engine.UseTablebase(t)
+	for _, setup := range drills {
+		endgameTables.Probe(setup.FEN) // starts generating the tables of the built-in drills
+	}
+	return nil
+}
+
+// maxTablebaseEvals limits the number of cached tablebase evaluations
+const maxTablebaseEvals = 10000
+
+var (
+	tablebaseEvals    = make(map[string]*TablebaseEval) // by position (the first 4 fields of the FEN)
+	tablebaseEvalsMtx sync.Mutex
+)
+
+// probeTablebase returns the tablebase evaluation of a position if it is covered and the table is ready
+func probeTablebase(fen string) *TablebaseEval {
+	if endgameTables == nil || pieceCount(fen) > tablebase.MaxPieces {
+		return nil
+	}
+	key := positionKey(fen)
+	tablebaseEvalsMtx.Lock()
+	eval, ok := tablebaseEvals[key]
+	tablebaseEvalsMtx.Unlock()
+	if ok {
+		return eval
+	}
+	move, result, ok := endgameTables.BestMove(fen)
+	if !ok {
+		// not cached, the tables of the position and its moves may be ready later
+		if result, ok := endgameTables.Probe(fen); ok {
+			return &TablebaseEval{Result: result, Text: result.String()}
+		}
+		return nil
+	}
+	eval = &TablebaseEval{Result: result, BestMove: move, Text: result.String()}
+	tablebaseEvalsMtx.Lock()
+	if len(tablebaseEvals) >= maxTablebaseEvals {
+		tablebaseEvals = make(map[string]*TablebaseEval)
+	}
+	tablebaseEvals[key] = eval
+	tablebaseEvalsMtx.Unlock()
+	return eval
+}
+
+// pieceCount counts the pieces in the piece placement field of a FEN
+func pieceCount(fen string) int {
+	placement, _, _ := strings.Cut(fen, " ")
+	count := 0
+	for _, c := range placement {
+		if strings.ContainsRune("PNBRQKpnbrqk", c) {
+			count++
+		}
+	}
+	return count
+}
+
+// positionKey returns the FEN without the move counters
+func positionKey(fen string) string {
+	fields := strings.Fields(fen)
+	if len(fields) > 4 {
+		fields = fields[:4]
+	}
+	return strings.Join(fields, " ")
+}
+
+// verifyPuzzles drops the puzzles covered by the tablebase where the side to move can't force a win.
+// It doesn't wait for the tables to be generated, the puzzles of the tables that aren't ready are kept.
+func verifyPuzzles(puzzles []string) []string {
+	if endgameTables == nil {
+		return puzzles
+	}
+	verified := make([]string, 0, len(puzzles))
+	var unverified int
+	for _, puzzle := range puzzles {
+		if tablebase.Covers(puzzle) {
+			result, ok := endgameTables.Probe(puzzle)
+			if !ok {
+				unverified++
+			} else if result.WDL != 1 {
+				log.Printf("invalid puzzle (%s according to the tablebase): %s", result, puzzle)
+				continue
+			}
+		}
+		verified = append(verified, puzzle)
+	}
+	if unverified > 0 {
+		log.Printf("%d puzzles couldn't be verified yet, their tables are being generated", unverified)
+	}
+	return verified
+}
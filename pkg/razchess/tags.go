@@ -0,0 +1,101 @@
+package razchess
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/notnil/chess"
+)
+
+const maxTagValueLength = 100
+
+// editableTags are the tag pairs that can be set by the clients with the format of their values (if any),
+// the others like Result, FEN and SetUp follow the game itself
+var editableTags = map[string]*regexp.Regexp{
+	"Event":     nil,
+	"Site":      nil,
+	"Date":      regexp.MustCompile(`^[0-9?]{4}\.[0-9?]{2}\.[0-9?]{2}$`),
+	"Round":     regexp.MustCompile(`^(\?|-|[0-9]+(\.[0-9]+)*)$`),
+	"White":     nil,
+	"Black":     nil,
+	"WhiteElo":  regexp.MustCompile(`^([0-9]{1,4}|-)$`),
+	"BlackElo":  regexp.MustCompile(`^([0-9]{1,4}|-)$`),
+	"Annotator": nil,
+}
+
+// validateTags checks that the tags are editable and their values are safe to write in PGN
+func validateTags(tags map[string]string) error {
+	for key, value := range tags {
+		format, ok := editableTags[key]
+		if !ok {
+			return fmt.Errorf("tag %s can't be edited", key)
+		}
+		if len(value) == 0 {
+			continue // removes the tag
+		}
+		if utf8.RuneCountInString(value) > maxTagValueLength || !utf8.ValidString(value) {
+			return fmt.Errorf("invalid %s: too long", key)
+		}
+		for _, r := range value {
+			if r == '"' || r == '\\' || unicode.IsControl(r) {
+				return fmt.Errorf("invalid %s: contains %q", key, r)
+			}
+		}
+		if format != nil && !format.MatchString(value) {
+			return fmt.Errorf("invalid %s: %s", key, value)
+		}
+	}
+	return nil
+}
+
+// setGameTags sets or removes (in case of an empty value) the tag pairs of a game, keeping the order of the existing ones.
+// The tag pairs are replaced instead of modified, because clones of the game share them.
+func setGameTags(game *chess.Game, tags map[string]string) {
+	existing := game.TagPairs()
+	for _, tag := range existing {
+		game.RemoveTagPair(tag.Key)
+	}
+	for _, tag := range existing {
+		value, ok := tags[tag.Key]
+		if !ok {
+			value = tag.Value
+		}
+		if len(value) > 0 {
+			game.AddTagPair(tag.Key, value)
+		}
+	}
+	var added []string
+	for key, value := range tags {
+		if game.GetTagPair(key) == nil && len(value) > 0 {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	sort.SliceStable(added, func(i, j int) bool { // the seven tag roster goes first
+		return tagOrder(added[i]) < tagOrder(added[j])
+	})
+	for _, key := range added {
+		game.AddTagPair(key, tags[key])
+	}
+}
+
+func tagOrder(key string) int {
+	for i, k := range exportTagOrder {
+		if k == key {
+			return i
+		}
+	}
+	return len(exportTagOrder)
+}
+
+// gameTags returns the tag pairs of a game as a map
+func gameTags(game *chess.Game) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range game.TagPairs() {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
@@ -0,0 +1,207 @@
+package razchess
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/chessimage"
+)
+
+// Theme is a set of board colors and piece images of the rendered images
+type Theme struct {
+	Name           string
+	Light          color.Color
+	Dark           color.Color
+	HighlightLight color.Color // last move on light squares
+	HighlightDark  color.Color // last move on dark squares
+	Check          color.Color
+	pieces         fs.FS  // images named like kl.png (white king) and pd.png (black pawn), nil means the chessimage set
+	assetPath      string // of the piece images in pieces
+	paletteOnce    sync.Once
+	palette        color.Palette
+	paletteErr     error
+}
+
+const defaultThemeName = "brown"
+
+// chessimageColors are the colors chessimage renders the boards with, the boards of the other themes are drawn
+// by renderBoard
+var chessimageColors = newTheme("chessimage", rgb(240, 217, 181), rgb(181, 136, 99), rgb(247, 193, 99), rgb(215, 149, 54), nil)
+
+var themes = map[string]*Theme{
+	"brown": newTheme("brown", rgb(240, 217, 181), rgb(181, 136, 99), rgb(247, 193, 99), rgb(215, 149, 54), nil),
+	"blue":  newTheme("blue", rgb(222, 227, 230), rgb(140, 162, 173), rgb(195, 216, 135), rgb(151, 172, 84), nil),
+	"green": newTheme("green", rgb(238, 238, 210), rgb(118, 150, 86), rgb(246, 246, 105), rgb(186, 202, 43), nil),
+	"gray":  newTheme("gray", rgb(220, 220, 220), rgb(160, 160, 160), rgb(236, 214, 120), rgb(196, 172, 80), nil),
+}
+
+func newTheme(name string, light, dark, highlightLight, highlightDark color.Color, pieces fs.FS) *Theme {
+	t := &Theme{
+		Name:           name,
+		Light:          light,
+		Dark:           dark,
+		HighlightLight: highlightLight,
+		HighlightDark:  highlightDark,
+		Check:          rgb(255, 0, 0),
+		pieces:         pieces,
+	}
+	return t
+}
+
+// pieceImage loads the image of a piece from the theme's piece set (or chessimage's built-in set)
+func (t *Theme) pieceImage(p chess.Piece) (image.Image, error) {
+	pieces := t.pieces
+	if pieces == nil {
+		pieces = chessimage.Assets() // vendored chessimage is patched to export its piece set
+	}
+	f, err := pieces.Open(t.assetPath + pieceImageName(p))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// gifPalette returns the GIF palette of the theme, generated from the piece images on first use
+func (t *Theme) gifPalette() (color.Palette, error) {
+	t.paletteOnce.Do(func() {
+		t.palette, t.paletteErr = getPalette(t)
+	})
+	return t.palette, t.paletteErr
+}
+
+// plain tells if the theme has the colors of chessimage
+func (t *Theme) plain() bool {
+	c := chessimageColors
+	return sameColor(t.Light, c.Light) && sameColor(t.Dark, c.Dark) && sameColor(t.HighlightLight, c.HighlightLight) &&
+		sameColor(t.HighlightDark, c.HighlightDark) && sameColor(t.Check, c.Check)
+}
+
+func sameColor(c1, c2 color.Color) bool {
+	return rgbaOf(c1) == rgbaOf(c2)
+}
+
+func rgbaOf(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+func defaultTheme() *Theme {
+	return themes[defaultThemeName]
+}
+
+// ThemeNames returns the names of the available themes
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getTheme(name string) (*Theme, error) {
+	if len(name) == 0 {
+		return defaultTheme(), nil
+	}
+	t, ok := themes[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid theme: %s (expected %s)", name, strings.Join(ThemeNames(), ", "))
+	}
+	return t, nil
+}
+
+// themeConfig is a theme in the themes file, the missing colors are taken from the default theme
+type themeConfig struct {
+	Name           string `json:"name"`
+	Light          string `json:"light"`
+	Dark           string `json:"dark"`
+	HighlightLight string `json:"highlight_light"`
+	HighlightDark  string `json:"highlight_dark"`
+	Check          string `json:"check"`
+	Pieces         string `json:"pieces"` // directory of the piece images
+}
+
+// LoadThemes adds the themes of a JSON file (a list of themes with hex colors and an optional piece set directory)
+// to the built-in ones
+func LoadThemes(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var configs []themeConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return err
+	}
+	loaded := make(map[string]*Theme)
+	for _, c := range configs {
+		t, err := c.theme()
+		if err != nil {
+			return fmt.Errorf("theme %q: %w", c.Name, err)
+		}
+		loaded[t.Name] = t
+	}
+	for name, t := range loaded {
+		themes[name] = t
+	}
+	return nil
+}
+
+func (c *themeConfig) theme() (*Theme, error) {
+	if len(c.Name) == 0 {
+		return nil, fmt.Errorf("missing name")
+	}
+	def := defaultTheme()
+	values := []string{c.Light, c.Dark, c.HighlightLight, c.HighlightDark, c.Check}
+	colors := []color.Color{def.Light, def.Dark, def.HighlightLight, def.HighlightDark, def.Check}
+	for i, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		clr, err := parseHexColor(value)
+		if err != nil {
+			return nil, err
+		}
+		colors[i] = clr
+	}
+	var pieces fs.FS
+	if len(c.Pieces) > 0 {
+		pieces = os.DirFS(c.Pieces)
+		if err := checkPieceSet(pieces); err != nil {
+			return nil, err
+		}
+	}
+	t := newTheme(c.Name, colors[0], colors[1], colors[2], colors[3], pieces)
+	t.Check = colors[4]
+	return t, nil
+}
+
+// parseHexColor parses colors like #f0d9b5
+func parseHexColor(s string) (color.Color, error) {
+	var r, g, b uint8
+	if len(s) != 7 || s[0] != '#' {
+		return nil, fmt.Errorf("invalid color: %s (expected something like #f0d9b5)", s)
+	}
+	if _, err := fmt.Sscanf(s[1:], "%02x%02x%02x", &r, &g, &b); err != nil {
+		return nil, fmt.Errorf("invalid color: %s (expected something like #f0d9b5)", s)
+	}
+	return rgb(r, g, b), nil
+}
+
+// checkPieceSet makes sure every piece image of a set exists
+func checkPieceSet(pieces fs.FS) error {
+	for _, name := range pieceImageNames() {
+		if _, err := fs.Stat(pieces, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
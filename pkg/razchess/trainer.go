@@ -0,0 +1,210 @@
+package razchess
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+const (
+	TrainerWeighted = "weighted"
+	TrainerRandom   = "random"
+)
+
+// Trainer is the state of an opening trainer session
+type Trainer struct {
+	Nickname  string   `json:"nickname,omitempty"`
+	Color     string   `json:"color"`
+	Lines     int      `json:"lines"`
+	Due       int      `json:"due"`
+	Completed int      `json:"completed"`
+	Mistakes  int      `json:"mistakes"`
+	Deviation string   `json:"deviation,omitempty"`
+	Expected  []string `json:"expected,omitempty"`
+}
+
+type trainer struct {
+	state    Trainer
+	rep      *repertoire
+	color    chess.Color
+	choice   string
+	node     *repertoireNode
+	failed   bool
+	progress map[string]*lineProgress
+	reps     *repetitions
+}
+
+func newTrainer(pgn string, color chess.Color, choice, nickname string, reps *repetitions) (*trainer, error) {
+	if len(nickname) > MaxNicknameLength {
+		return nil, fmt.Errorf("nickname is too long")
+	}
+	if color == chess.NoColor {
+		return nil, fmt.Errorf("invalid color")
+	}
+	switch choice {
+	case "":
+		choice = TrainerWeighted
+	case TrainerWeighted, TrainerRandom:
+	default:
+		return nil, fmt.Errorf("unknown move choice: %s", choice)
+	}
+	rep, err := parseRepertoire(pgn)
+	if err != nil {
+		return nil, err
+	}
+	if !rep.root.hasMovesFor(color) {
+		return nil, fmt.Errorf("the repertoire has no moves for %s", strings.ToLower(color.Name()))
+	}
+	t := &trainer{
+		state: Trainer{
+			Nickname: nickname,
+			Color:    color.Name(),
+			Lines:    rep.lines,
+		},
+		rep:      rep,
+		color:    color,
+		choice:   choice,
+		progress: make(map[string]*lineProgress),
+		reps:     reps,
+	}
+	if len(nickname) > 0 {
+		t.progress = reps.get(nickname)
+	}
+	return t, nil
+}
+
+func (t *trainer) start(sess *Session) {
+	t.restart(sess)
+}
+
+func (t *trainer) handleMove(sess *Session, move *chess.Move) bool {
+	pos := sess.game.Position()
+	if pos.Turn() != t.color || len(t.node.children) == 0 {
+		return false
+	}
+	next := t.node.findChild(move)
+	if next == nil {
+		t.failed = true
+		t.state.Mistakes++
+		t.state.Deviation = chess.AlgebraicNotation{}.Encode(pos, move)
+		t.state.Expected = nil
+		for _, child := range t.node.children {
+			t.state.Expected = append(t.state.Expected, child.san())
+		}
+		sess.updateClients()
+		return false
+	}
+	if !sess.handleMove(next.move) {
+		return false
+	}
+	t.state.Deviation = ""
+	t.state.Expected = nil
+	t.node = next
+	sess.updateClients()
+
+	if len(t.node.children) > 0 {
+		<-time.NewTimer(time.Second / 2).C
+		t.reply(sess)
+		sess.updateClients()
+	}
+	if len(t.node.children) == 0 {
+		t.lineDone(sess)
+	}
+	return true
+}
+
+func (t *trainer) handleResign(sess *Session, color chess.Color) {
+	if color == t.color {
+		t.restart(sess)
+	}
+}
+
+func (t *trainer) decorate(u *Update) {
+	state := t.state
+	u.Trainer = &state
+	u.Orientation = strings.ToLower(t.state.Color)
+	if len(state.Deviation) > 0 {
+		u.Status = fmt.Sprintf("%s is not in the repertoire, expected: %s", state.Deviation, strings.Join(state.Expected, ", "))
+	}
+}
+
+func (t *trainer) restart(sess *Session) {
+	sess.game = newGameFromFEN(t.rep.root.pos.String())
+	t.node = t.rep.root
+	t.failed = false
+	t.state.Deviation = ""
+	t.state.Expected = nil
+	t.state.Due = t.countDue()
+	if sess.game.Position().Turn() != t.color {
+		t.reply(sess)
+	}
+}
+
+func (t *trainer) reply(sess *Session) {
+	next := t.pickReply()
+	if sess.handleMove(next.move) {
+		t.node = next
+	}
+}
+
+func (t *trainer) pickReply() *repertoireNode {
+	var children []*repertoireNode
+	for _, child := range t.node.children {
+		if child.hasMovesFor(t.color) {
+			children = append(children, child)
+		}
+	}
+	if len(children) == 0 {
+		children = t.node.children
+	}
+	if t.choice == TrainerRandom || len(children) == 1 {
+		return children[rand.Intn(len(children))]
+	}
+	now := time.Now()
+	weights := make([]float64, len(children))
+	var sum float64
+	for i, child := range children {
+		for _, leaf := range child.leaves() {
+			weights[i] += t.progress[leaf.line].weight(now)
+		}
+		sum += weights[i]
+	}
+	r := rand.Float64() * sum
+	for i, w := range weights {
+		if r < w {
+			return children[i]
+		}
+		r -= w
+	}
+	return children[len(children)-1]
+}
+
+func (t *trainer) lineDone(sess *Session) {
+	line := t.node.line
+	p := t.progress[line]
+	if p == nil {
+		p = &lineProgress{}
+		t.progress[line] = p
+	}
+	p.grade(!t.failed, time.Now())
+	if len(t.state.Nickname) > 0 {
+		t.reps.set(t.state.Nickname, line, *p)
+	}
+	t.state.Completed++
+	<-time.NewTimer(time.Second).C
+	t.restart(sess)
+	sess.updateClients()
+}
+
+func (t *trainer) countDue() (due int) {
+	now := time.Now()
+	for _, leaf := range t.rep.root.leaves() {
+		if t.progress[leaf.line].isDue(now) {
+			due++
+		}
+	}
+	return
+}
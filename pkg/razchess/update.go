@@ -4,32 +4,41 @@ import (
 	"strings"
 
 	"github.com/notnil/chess"
-	"github.com/notnil/chess/opening"
 )
 
-var book opening.Book = opening.NewBookECO()
-
 type Move [2]string
 
 type Update struct {
-	Move          Move   `json:"move,omitempty"`
-	Turn          string `json:"turn"`
-	Status        string `json:"status"`
-	FEN           string `json:"fen,omitempty"`
-	PGN           string `json:"pgn,omitempty"`
-	Opening       string `json:"opening,omitempty"`
-	IsCapture     bool   `json:"isCapture"`
-	IsGameOver    bool   `json:"isGameOver"`
-	CheckedSquare string `json:"checkedSquare,omitempty"`
+	Move          Move           `json:"move,omitempty"`
+	Turn          string         `json:"turn"`
+	Status        string         `json:"status"`
+	FEN           string         `json:"fen,omitempty"`
+	PGN           string         `json:"pgn,omitempty"`
+	Opening       string         `json:"opening,omitempty"`
+	ECO           string         `json:"eco,omitempty"`
+	IsCapture     bool           `json:"isCapture"`
+	IsGameOver    bool           `json:"isGameOver"`
+	CheckedSquare string         `json:"checkedSquare,omitempty"`
+	Orientation   string         `json:"orientation,omitempty"`
+	PuzzleRun     *PuzzleRun     `json:"puzzleRun,omitempty"`
+	Trainer       *Trainer       `json:"trainer,omitempty"`
+	GuessTheMove  *GuessTheMove  `json:"guessTheMove,omitempty"`
+	Drill         *Drill         `json:"drill,omitempty"`
+	Problem       *Problem       `json:"problem,omitempty"`
+	Tablebase     *TablebaseEval `json:"tablebase,omitempty"`
 }
 
 func newUpdate(game *chess.Game) *Update {
+	opening := findOpening(game)
 	u := &Update{
 		Turn: game.Position().Turn().String(),
 		FEN:  game.FEN(),
-		PGN:  strings.TrimSpace(game.String()),
+		PGN:  strings.TrimSpace(withOpeningTags(game, opening).String()),
 	}
 	u.Status, u.IsGameOver = getStatus(game)
+	if !u.IsGameOver {
+		u.Tablebase = probeTablebase(u.FEN)
+	}
 	if lastMove := getLastMove(game); lastMove != nil {
 		u.Move[0] = lastMove.S1().String()
 		u.Move[1] = lastMove.S2().String()
@@ -40,10 +49,9 @@ func newUpdate(game *chess.Game) *Update {
 			u.CheckedSquare = game.Position().Board().KingSquare(game.Position().Turn()).String()
 		}
 	}
-	if game.Positions()[0].String() == StartingFEN {
-		if opening := book.Find(game.Moves()); opening != nil {
-			u.Opening = opening.Title()
-		}
+	if opening != nil {
+		u.Opening = opening.Title()
+		u.ECO = opening.Code()
 	}
 	return u
 }
@@ -152,12 +152,22 @@ func parseGame(game string) ([]func(*chess.Game), error) {
 }
 
 func gameToString(game *chess.Game) string {
-	if len(game.Moves()) > 0 {
+	if len(game.Moves()) > 0 || hasCustomTags(game) {
 		return "pgn:" + strings.TrimSpace(game.String())
 	}
 	return "fen:" + game.FEN()
 }
 
+// hasCustomTags returns whether the game has tag pairs other than the ones of a FEN game, which are lost in FEN format
+func hasCustomTags(game *chess.Game) bool {
+	for _, tag := range game.TagPairs() {
+		if tag.Key != "SetUp" && tag.Key != "FEN" {
+			return true
+		}
+	}
+	return false
+}
+
 func ParsePGN(PGN string) (startingFEN string, moves []string, err error) {
 	startingFEN = StartingFEN
 	ps := pgn.NewPGNScanner(strings.NewReader(PGN))
@@ -177,3 +187,44 @@ func ParsePGN(PGN string) (startingFEN string, moves []string, err error) {
 	}
 	return
 }
+
+// SplitPGN splits a PGN database into separate games
+func SplitPGN(pgn string) (games []string) {
+	var game strings.Builder
+	inMoves := false
+	for _, line := range strings.Split(strings.ReplaceAll(pgn, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		isTag := strings.HasPrefix(trimmed, "[")
+		if isTag && inMoves {
+			if g := strings.TrimSpace(game.String()); len(g) > 0 {
+				games = append(games, g)
+			}
+			game.Reset()
+			inMoves = false
+		} else if !isTag && len(trimmed) > 0 {
+			inMoves = true
+		}
+		game.WriteString(line)
+		game.WriteByte('\n')
+	}
+	if g := strings.TrimSpace(game.String()); len(g) > 0 {
+		games = append(games, g)
+	}
+	return
+}
+
+func getTagValue(game *chess.Game, key string) string {
+	if tag := game.GetTagPair(key); tag != nil {
+		return tag.Value
+	}
+	return ""
+}
+
+// newGameFromFEN creates a game that only has the FEN tag pairs if it's not the standard starting position
+func newGameFromFEN(fen string) *chess.Game {
+	if fen == StartingFEN {
+		return chess.NewGame()
+	}
+	opts, _ := parseGame(fen)
+	return chess.NewGame(opts...)
+}
@@ -0,0 +1,228 @@
+package tablebase
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/razzie/razchess/pkg/board"
+)
+
+// table values: 0 is a draw, 1+N is a win (odd N) or a loss (even N) of the side to move in N plies
+const (
+	draw     uint8 = 0
+	invalid  uint8 = 255
+	maxPlies       = 250
+	resolved uint8 = 254 // draw found during generation
+	drawExit uint8 = 254
+	winExit  uint8 = 255
+)
+
+type table struct {
+	*material
+	values []uint8
+}
+
+func (t *table) probe(b *board.Board) uint8 {
+	return t.values[t.index(b)]
+}
+
+// generator builds a table by retrograde analysis, starting from the checkmates and the positions
+// that convert into already known tables by a capture or a promotion
+type generator struct {
+	*material
+	deps     map[string]*table
+	values   []uint8
+	counters []uint8 // number of different unresolved successor positions in the same table
+	exits    []uint8 // best known outcome of the captures and promotions
+	buckets  [maxPlies + 1][]int32
+}
+
+func generate(m *material, deps map[string]*table) *table {
+	size := m.size()
+	g := &generator{
+		material: m,
+		deps:     deps,
+		values:   make([]uint8, size),
+		counters: make([]uint8, size),
+		exits:    make([]uint8, size),
+	}
+	g.init()
+	g.retrograde()
+	for i, v := range g.values {
+		if v == resolved {
+			g.values[i] = draw
+		}
+	}
+	return &table{material: m, values: g.values}
+}
+
+// init evaluates the moves of every position in parallel
+func (g *generator) init() {
+	workers := runtime.NumCPU()
+	chunk := (len(g.values) + workers - 1) / workers
+	buckets := make([][maxPlies + 1][]int32, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		from, to := w*chunk, (w+1)*chunk
+		if to > len(g.values) {
+			to = len(g.values)
+		}
+		wg.Add(1)
+		go func(w, from, to int) {
+			defer wg.Done()
+			b := board.New()
+			for idx := from; idx < to; idx++ {
+				if ply, ok := g.initPosition(idx, b); ok {
+					buckets[w][ply] = append(buckets[w][ply], int32(idx))
+				}
+			}
+		}(w, from, to)
+	}
+	wg.Wait()
+	for w := range buckets {
+		for ply := range buckets[w] {
+			g.buckets[ply] = append(g.buckets[ply], buckets[w][ply]...)
+		}
+	}
+}
+
+// initPosition counts the successors of a position and evaluates its exits,
+// it returns the ply where the position has to be resolved (if known yet)
+func (g *generator) initPosition(idx int, b *board.Board) (int, bool) {
+	if !g.position(idx, b) || g.index(b) != idx {
+		g.values[idx] = invalid
+		return 0, false
+	}
+	var buf [128]board.Move
+	moves := b.LegalMoves(buf[:0])
+	if len(moves) == 0 {
+		if b.InCheck() {
+			return 0, true
+		}
+		g.values[idx] = resolved
+		return 0, false
+	}
+
+	var successors [128]int
+	count := 0
+	winPly, lossPly, hasDraw := -1, 0, false
+	for _, m := range moves {
+		b.Make(&m)
+		if m.Captured == board.NoPiece && m.Promo == board.NoPieceType {
+			child := g.index(b)
+			if !contains(successors[:count], child) {
+				successors[count] = child
+				count++
+			}
+		} else {
+			dep, flip := materialOf(b)
+			v := g.deps[dep.name].probe(flipped(b, flip))
+			switch {
+			case v == draw:
+				hasDraw = true
+			case (v-1)%2 == 0: // the opponent loses
+				if ply := int(v); ply <= maxPlies && (winPly < 0 || ply < winPly) {
+					winPly = ply
+				}
+			case int(v) > lossPly:
+				lossPly = int(v)
+			}
+		}
+		b.Unmake(&m)
+	}
+
+	g.counters[idx] = uint8(count)
+	switch {
+	case winPly >= 0:
+		g.exits[idx] = winExit
+		return winPly, true
+	case hasDraw:
+		g.exits[idx] = drawExit
+	case lossPly > 0 && lossPly <= maxPlies:
+		g.exits[idx] = uint8(lossPly)
+	}
+	if count == 0 {
+		if g.exits[idx] == drawExit || lossPly > maxPlies {
+			g.values[idx] = resolved
+			return 0, false
+		}
+		return lossPly, true
+	}
+	return 0, false
+}
+
+// retrograde resolves the positions ply by ply going backwards from the known ones
+func (g *generator) retrograde() {
+	b := board.New()
+	var buf [256]board.Move
+	var predecessors [256]int
+	for ply := 0; ply <= maxPlies; ply++ {
+		var frontier []int32
+		for _, idx := range g.buckets[ply] {
+			if g.values[idx] == 0 {
+				g.values[idx] = uint8(ply + 1)
+				frontier = append(frontier, idx)
+			}
+		}
+		g.buckets[ply] = nil
+		if ply == maxPlies {
+			break
+		}
+
+		for _, idx := range frontier {
+			g.position(int(idx), b)
+			count := 0
+			for _, m := range b.RetroMoves(buf[:0]) {
+				b.Unmake(&m)
+				if pred := g.index(b); g.values[pred] == 0 && !contains(predecessors[:count], pred) {
+					predecessors[count] = pred
+					count++
+				}
+				b.Make(&m)
+			}
+			for _, pred := range predecessors[:count] {
+				if ply%2 == 0 { // the position is lost, so the predecessor is won
+					g.buckets[ply+1] = append(g.buckets[ply+1], int32(pred))
+					continue
+				}
+				g.counters[pred]--
+				if g.counters[pred] > 0 {
+					continue
+				}
+				switch exit := g.exits[pred]; {
+				case exit == winExit:
+				case exit == drawExit:
+					g.values[pred] = resolved
+				case int(exit) > ply+1:
+					g.buckets[exit] = append(g.buckets[exit], int32(pred))
+				default:
+					g.buckets[ply+1] = append(g.buckets[ply+1], int32(pred))
+				}
+			}
+		}
+	}
+}
+
+func contains(list []int, value int) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// flipped returns the position with the colors swapped if flip is true
+func flipped(b *board.Board, flip bool) *board.Board {
+	if !flip {
+		return b
+	}
+	f := board.New()
+	for sq, p := range b.Squares {
+		if p != board.NoPiece {
+			f.Put(board.Square(sq)^56, board.NewPiece(p.Type(), p.Color().Other()))
+		}
+	}
+	f.Turn = b.Turn.Other()
+	return f
+}
@@ -0,0 +1,131 @@
+package tablebase
+
+import (
+	"github.com/razzie/razchess/pkg/board"
+)
+
+var (
+	symmetries     [8][64]board.Square // the first two are the identity and the file mirror (used for pawn tables)
+	pawnlessRegion [64]int             // a1-d1-d4 triangle
+	pawnRegion     [64]int             // files a-d
+	pawnlessKings  []board.Square
+	pawnKings      []board.Square
+)
+
+func init() {
+	for sq := board.Square(0); sq < 64; sq++ {
+		f, r := sq.File(), sq.Rank()
+		symmetries[0][sq] = sq
+		symmetries[1][sq] = board.NewSquare(7-f, r)
+		symmetries[2][sq] = board.NewSquare(f, 7-r)
+		symmetries[3][sq] = board.NewSquare(7-f, 7-r)
+		symmetries[4][sq] = board.NewSquare(r, f)
+		symmetries[5][sq] = board.NewSquare(7-r, f)
+		symmetries[6][sq] = board.NewSquare(r, 7-f)
+		symmetries[7][sq] = board.NewSquare(7-r, 7-f)
+
+		pawnlessRegion[sq], pawnRegion[sq] = -1, -1
+		if f <= 3 && r <= f {
+			pawnlessRegion[sq] = len(pawnlessKings)
+			pawnlessKings = append(pawnlessKings, sq)
+		}
+		if f <= 3 {
+			pawnRegion[sq] = len(pawnKings)
+			pawnKings = append(pawnKings, sq)
+		}
+	}
+}
+
+// size returns the number of indices of the table
+func (m *material) size() int {
+	size := len(pawnlessKings)
+	if m.pawns {
+		size = len(pawnKings)
+	}
+	for i := 1; i < len(m.pieces); i++ {
+		size *= 64
+	}
+	return size * 2
+}
+
+// index returns the canonical index of a position with matching material (in white/black orientation),
+// positions related by a symmetry share the same index
+func (m *material) index(b *board.Board) int {
+	var squares [MaxPieces]board.Square
+	n := len(m.pieces)
+	filled := 0
+	for sq := board.Square(0); sq < 64; sq++ {
+		p := b.Squares[sq]
+		if p == board.NoPiece {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if m.pieces[i] == p && filled&(1<<i) == 0 {
+				squares[i] = sq
+				filled |= 1 << i
+				break
+			}
+		}
+	}
+
+	region, syms := &pawnlessRegion, 8
+	if m.pawns {
+		region, syms = &pawnRegion, 2
+	}
+	best := -1
+	for s := 0; s < syms; s++ {
+		sym := &symmetries[s]
+		kingIdx := region[sym[squares[0]]]
+		if kingIdx < 0 {
+			continue
+		}
+		var t [MaxPieces]board.Square
+		for i := 1; i < n; i++ {
+			t[i] = sym[squares[i]]
+		}
+		for i := 2; i+1 < n; i++ {
+			if m.pieces[i] == m.pieces[i+1] && t[i] > t[i+1] {
+				t[i], t[i+1] = t[i+1], t[i]
+			}
+		}
+		idx := kingIdx
+		for i := 1; i < n; i++ {
+			idx = idx*64 + int(t[i])
+		}
+		idx = idx*2 + int(b.Turn)
+		if best < 0 || idx < best {
+			best = idx
+		}
+	}
+	return best
+}
+
+// position sets up the board of an index and tells if it is a valid canonical position
+func (m *material) position(idx int, b *board.Board) bool {
+	n := len(m.pieces)
+	b.Clear()
+	b.Turn = board.Color(idx & 1)
+	idx >>= 1
+	var squares [MaxPieces]board.Square
+	for i := n - 1; i > 0; i-- {
+		squares[i] = board.Square(idx & 63)
+		idx >>= 6
+	}
+	if m.pawns {
+		squares[0] = pawnKings[idx]
+	} else {
+		squares[0] = pawnlessKings[idx]
+	}
+	for i := 0; i < n; i++ {
+		sq := squares[i]
+		if b.Squares[sq] != board.NoPiece {
+			return false
+		}
+		if m.pieces[i].Type() == board.Pawn && (sq.Rank() == 0 || sq.Rank() == 7) {
+			return false
+		}
+		b.Put(sq, m.pieces[i])
+	}
+	them := b.Turn.Other()
+	return !b.IsAttacked(b.KingSquare(them), b.Turn)
+}
@@ -0,0 +1,144 @@
+package tablebase
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/razzie/razchess/pkg/board"
+)
+
+var (
+	pieceOrder  = []board.PieceType{board.King, board.Queen, board.Rook, board.Bishop, board.Knight, board.Pawn}
+	pieceValues = map[board.PieceType]int{board.Queen: 9, board.Rook: 5, board.Bishop: 3, board.Knight: 3, board.Pawn: 1}
+)
+
+// material is the piece set of a table in index order: the kings first, then the other white and black pieces
+type material struct {
+	name   string
+	pieces []board.Piece
+	pawns  bool
+}
+
+// parseMaterial parses a material signature like KRvKP, the stronger side is always stored as white
+func parseMaterial(signature string) (*material, error) {
+	sides := strings.Split(strings.ToUpper(signature), "V")
+	if len(sides) != 2 {
+		return nil, fmt.Errorf("invalid material signature: %s", signature)
+	}
+	var counts [2][7]int
+	for i, side := range sides {
+		for _, c := range side {
+			idx := strings.IndexRune(" PNBRQK", c)
+			if idx < 1 {
+				return nil, fmt.Errorf("invalid piece in material signature: %c", c)
+			}
+			counts[i][idx]++
+		}
+		if counts[i][board.King] != 1 {
+			return nil, fmt.Errorf("invalid material signature: %s", signature)
+		}
+	}
+	m := newMaterial(counts)
+	if len(m.pieces) > MaxPieces {
+		return nil, fmt.Errorf("only tables up to %d pieces are supported", MaxPieces)
+	}
+	return m, nil
+}
+
+// materialOf returns the material of a position and tells if the colors have to be flipped to match it
+func materialOf(b *board.Board) (*material, bool) {
+	var counts [2][7]int
+	for _, p := range b.Squares {
+		if p != board.NoPiece {
+			counts[p.Color()][p.Type()]++
+		}
+	}
+	flip := isStronger(counts[board.Black], counts[board.White])
+	if flip {
+		counts[0], counts[1] = counts[1], counts[0]
+	}
+	return newMaterial(counts), flip
+}
+
+func newMaterial(counts [2][7]int) *material {
+	if isStronger(counts[1], counts[0]) {
+		counts[0], counts[1] = counts[1], counts[0]
+	}
+	m := &material{
+		pieces: []board.Piece{board.NewPiece(board.King, board.White), board.NewPiece(board.King, board.Black)},
+		pawns:  counts[0][board.Pawn]+counts[1][board.Pawn] > 0,
+	}
+	var name [2]string
+	for color := board.White; color <= board.Black; color++ {
+		for _, pt := range pieceOrder {
+			for i := 0; i < counts[color][pt]; i++ {
+				name[color] += strings.ToUpper(board.NewPiece(pt, board.White).String())
+				if pt != board.King {
+					m.pieces = append(m.pieces, board.NewPiece(pt, color))
+				}
+			}
+		}
+	}
+	m.name = name[0] + "v" + name[1]
+	return m
+}
+
+// isStronger tells if the first piece set is stronger than the second one (or equal but sorts first)
+func isStronger(a, b [7]int) bool {
+	var va, vb int
+	for pt, value := range pieceValues {
+		va += a[pt] * value
+		vb += b[pt] * value
+	}
+	if va != vb {
+		return va > vb
+	}
+	for _, pt := range pieceOrder {
+		if a[pt] != b[pt] {
+			return a[pt] > b[pt]
+		}
+	}
+	return false
+}
+
+// dependencies returns the materials reachable by a capture or a promotion
+func (m *material) dependencies() (deps []*material) {
+	var counts [2][7]int
+	for _, p := range m.pieces {
+		counts[p.Color()][p.Type()]++
+	}
+	seen := map[string]bool{m.name: true}
+	add := func(c [2][7]int) {
+		if dep := newMaterial(c); !seen[dep.name] {
+			seen[dep.name] = true
+			deps = append(deps, dep)
+		}
+	}
+	for color := 0; color < 2; color++ {
+		for pt := board.Pawn; pt < board.King; pt++ {
+			if counts[color][pt] == 0 {
+				continue
+			}
+			c := counts
+			c[color][pt]--
+			add(c) // capture
+			if pt != board.Pawn {
+				continue
+			}
+			for promo := board.Knight; promo <= board.Queen; promo++ {
+				c := counts
+				c[color][board.Pawn]--
+				c[color][promo]++
+				add(c) // promotion
+				for other := board.Pawn; other < board.King; other++ {
+					if c[1-color][other] > 0 {
+						cc := c
+						cc[1-color][other]--
+						add(cc) // capturing promotion
+					}
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,303 @@
+// Package tablebase generates and probes endgame tables of positions with a few pieces.
+// The tables are built locally by retrograde analysis and cached on disk.
+// Castling rights are not covered and en passant captures are only considered at the probed position.
+package tablebase
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/razzie/razchess/pkg/board"
+)
+
+// MaxPieces is the maximum number of pieces (including the kings) of the supported positions
+const MaxPieces = 4
+
+// Result is the value of a position from the side to move's point of view
+type Result struct {
+	WDL int `json:"wdl"` // 1: win, 0: draw, -1: loss
+	DTM int `json:"dtm"` // distance to mate in plies
+}
+
+// MateIn returns the number of moves until mate (negative if the side to move gets mated)
+func (r *Result) MateIn() int {
+	return r.WDL * (r.DTM + 1) / 2
+}
+
+func (r *Result) String() string {
+	switch r.WDL {
+	case 1:
+		return fmt.Sprintf("win, mate in %d", r.MateIn())
+	case -1:
+		return fmt.Sprintf("loss, mated in %d", -r.MateIn())
+	default:
+		return "draw"
+	}
+}
+
+func newResult(v uint8) *Result {
+	switch {
+	case v == draw:
+		return &Result{}
+	case (v-1)%2 == 1:
+		return &Result{WDL: 1, DTM: int(v) - 1}
+	default:
+		return &Result{WDL: -1, DTM: int(v) - 1}
+	}
+}
+
+// Tablebase manages the tables in a cache directory
+type Tablebase struct {
+	dir     string
+	mtx     sync.Mutex
+	tables  map[string]*table
+	loading map[string]chan struct{}
+}
+
+// Open returns a tablebase that stores the generated tables in dir
+func Open(dir string) (*Tablebase, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Tablebase{
+		dir:     dir,
+		tables:  make(map[string]*table),
+		loading: make(map[string]chan struct{}),
+	}, nil
+}
+
+// Covers tells if the position is legal and has few enough pieces and no castling rights
+func Covers(fen string) bool {
+	b, err := board.FromFEN(fen)
+	return err == nil && covers(b)
+}
+
+func covers(b *board.Board) bool {
+	return b.PieceCount() <= MaxPieces && b.Castling == 0 && !b.IsAttacked(b.KingSquare(b.Turn.Other()), b.Turn)
+}
+
+// Generate loads or generates the table of a material signature (like KRvKP) and its dependencies
+func (tb *Tablebase) Generate(material string) error {
+	m, err := parseMaterial(material)
+	if err != nil {
+		return err
+	}
+	_, err = tb.load(m)
+	return err
+}
+
+// Probe returns the value of a position if its table is available, a table cached on disk is loaded right away
+// and a missing one is generated in the background
+func (tb *Tablebase) Probe(fen string) (*Result, bool) {
+	return tb.probeFEN(fen, true)
+}
+
+// ProbeWait returns the value of a position, waiting for its table to be loaded or generated if needed
+func (tb *Tablebase) ProbeWait(fen string) (*Result, bool) {
+	return tb.probeFEN(fen, false)
+}
+
+func (tb *Tablebase) probeFEN(fen string, async bool) (*Result, bool) {
+	b, err := board.FromFEN(fen)
+	if err != nil || !covers(b) {
+		return nil, false
+	}
+	v, ok := tb.probe(b, async)
+	if !ok {
+		return nil, false
+	}
+	return newResult(v), true
+}
+
+// BestMove returns the move (in UCI notation) that wins the fastest, holds the draw or delays the loss the most
+func (tb *Tablebase) BestMove(fen string) (string, *Result, bool) {
+	b, err := board.FromFEN(fen)
+	if err != nil || !covers(b) {
+		return "", nil, false
+	}
+	move, v, ok := tb.search(b, true)
+	if !ok || move == nil {
+		return "", nil, false
+	}
+	return move.String(), newResult(v), true
+}
+
+// probe returns the table value of a position (searching one ply if en passant is possible)
+func (tb *Tablebase) probe(b *board.Board, async bool) (uint8, bool) {
+	if b.EnPassant != board.NoSquare {
+		_, v, ok := tb.search(b, async)
+		return v, ok
+	}
+	m, flip := materialOf(b)
+	t := tb.table(m, async)
+	if t == nil {
+		return 0, false
+	}
+	return t.probe(flipped(b, flip)), true
+}
+
+// search finds the best move of a position by probing the positions after each legal move
+func (tb *Tablebase) search(b *board.Board, async bool) (best *board.Move, value uint8, ok bool) {
+	var buf [128]board.Move
+	moves := b.LegalMoves(buf[:0])
+	if len(moves) == 0 {
+		if b.InCheck() {
+			return nil, 1, true
+		}
+		return nil, draw, true
+	}
+	bestScore := 0
+	for i := range moves {
+		m := &moves[i]
+		b.Make(m)
+		v, found := tb.probe(b, async)
+		b.Unmake(m)
+		if !found {
+			return nil, 0, false
+		}
+		// the value of the move for the side to move
+		var score int
+		var result uint8
+		switch {
+		case v == draw:
+			score, result = 0, draw
+		case (v-1)%2 == 0: // the opponent loses
+			score, result = 1000-int(v), v+1
+		default:
+			score, result = -1000+int(v), v+1
+		}
+		if best == nil || score > bestScore {
+			best, bestScore, value = m, score, result
+		}
+	}
+	return best, value, true
+}
+
+// table returns a loaded table, if async is true a table that isn't cached on disk is generated in the background
+func (tb *Tablebase) table(m *material, async bool) *table {
+	tb.mtx.Lock()
+	t := tb.tables[m.name]
+	tb.mtx.Unlock()
+	if t != nil {
+		return t
+	}
+	if async && !tb.cached(m) {
+		go func() {
+			if _, err := tb.load(m); err != nil {
+				log.Println("tablebase:", err)
+			}
+		}()
+		return nil
+	}
+	t, _ = tb.load(m)
+	return t
+}
+
+// cached tells if the table is cached on disk
+func (tb *Tablebase) cached(m *material) bool {
+	_, err := os.Stat(tb.filename(m))
+	return err == nil
+}
+
+func (tb *Tablebase) filename(m *material) string {
+	return filepath.Join(tb.dir, m.name+".tb")
+}
+
+// load loads the table from disk or generates it (only once even if called concurrently)
+func (tb *Tablebase) load(m *material) (*table, error) {
+	tb.mtx.Lock()
+	if t, ok := tb.tables[m.name]; ok {
+		tb.mtx.Unlock()
+		return t, nil
+	}
+	done, isLoading := tb.loading[m.name]
+	if !isLoading {
+		done = make(chan struct{})
+		tb.loading[m.name] = done
+	}
+	tb.mtx.Unlock()
+
+	if isLoading {
+		<-done
+		tb.mtx.Lock()
+		defer tb.mtx.Unlock()
+		if t, ok := tb.tables[m.name]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("failed to load table %s", m.name)
+	}
+
+	t, err := tb.loadOrGenerate(m)
+	tb.mtx.Lock()
+	if err == nil {
+		tb.tables[m.name] = t
+	}
+	delete(tb.loading, m.name)
+	tb.mtx.Unlock()
+	close(done)
+	return t, err
+}
+
+func (tb *Tablebase) loadOrGenerate(m *material) (*table, error) {
+	filename := tb.filename(m)
+	if values, err := readValues(filename, m.size()); err == nil {
+		return &table{material: m, values: values}, nil
+	}
+	deps := make(map[string]*table)
+	for _, dep := range m.dependencies() {
+		t, err := tb.load(dep)
+		if err != nil {
+			return nil, err
+		}
+		deps[dep.name] = t
+	}
+	log.Println("tablebase: generating", m.name)
+	t := generate(m, deps)
+	if err := writeValues(filename, t.values); err != nil {
+		log.Println("tablebase: failed to save", m.name, "-", err)
+	}
+	return t, nil
+}
+
+func readValues(filename string, size int) ([]uint8, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint8, size)
+	if _, err := io.ReadFull(r, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func writeValues(filename string, values []uint8) error {
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(f)
+	if _, err := w.Write(values); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,113 @@
+package tablebase
+
+import (
+	"testing"
+
+	"github.com/razzie/razchess/pkg/board"
+)
+
+func TestProbe(t *testing.T) {
+	tb, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		fen    string
+		wdl    int
+		mateIn int
+	}{
+		{"7k/8/6K1/8/8/8/Q7/8 w - - 0 1", 1, 1},   // Qa8#
+		{"Q6k/8/6K1/8/8/8/8/8 b - - 0 1", -1, 0},  // checkmated
+		{"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", 0, 0},  // stalemate
+		{"8/8/8/8/8/8/8/Kq5k w - - 0 1", 0, 0},    // the only move captures the queen
+		{"8/8/8/8/8/1k6/p7/K7 w - - 0 1", 0, 0},   // stalemate
+		{"8/8/8/8/8/8/k1K5/8 w - - 0 1", 0, 0},    // bare kings
+		{"8/8/8/8/8/8/8/KB5k w - - 0 1", 0, 0},    // insufficient material
+		{"4k3/4P3/4K3/8/8/8/8/8 b - - 0 1", 0, 0}, // stalemate
+	}
+	for _, test := range tests {
+		result, ok := tb.ProbeWait(test.fen)
+		if !ok {
+			t.Errorf("%s: not found", test.fen)
+			continue
+		}
+		if result.WDL != test.wdl || result.MateIn() != test.mateIn {
+			t.Errorf("%s: %s (wdl %d, mate in %d), expected wdl %d, mate in %d", test.fen, result, result.WDL, result.MateIn(), test.wdl, test.mateIn)
+		}
+	}
+
+	// the king in front of its pawn on the 6th rank wins regardless of the side to move
+	for fen, wdl := range map[string]int{"4k3/8/4K3/4P3/8/8/8/8 w - - 0 1": 1, "4k3/8/4K3/4P3/8/8/8/8 b - - 0 1": -1} {
+		if result, ok := tb.ProbeWait(fen); !ok || result.WDL != wdl {
+			t.Errorf("%s: %v, expected wdl %d", fen, result, wdl)
+		}
+	}
+
+	for _, fen := range []string{"8/8/8/8/8/8/6k1/4K2R w K - 0 1", "8/8/8/8/8/8/5pk1/4KRR1 w - - 0 1", "8/8/8/8/8/8/7k/4K1Q1 w - - 0 1"} {
+		if _, ok := tb.ProbeWait(fen); ok {
+			t.Errorf("%s: should not be covered", fen)
+		}
+	}
+}
+
+// TestLongestMates checks the longest forced mates of some tables against their known lengths
+func TestLongestMates(t *testing.T) {
+	tb, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		material string
+		moves    int // with white to move
+	}{
+		{"KQvK", 10},
+		{"KRvK", 16},
+		{"KBNvK", 33},
+	}
+	for _, test := range tests {
+		m, _ := parseMaterial(test.material)
+		if testing.Short() && len(m.pieces) > 3 {
+			continue // takes a while to generate
+		}
+		table, err := tb.load(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		longest := 0
+		for idx, v := range table.values {
+			if v == invalid || v == draw || board.Color(idx&1) != board.White {
+				continue
+			}
+			if result := newResult(v); result.WDL == 1 && result.MateIn() > longest {
+				longest = result.MateIn()
+			}
+		}
+		if longest != test.moves {
+			t.Errorf("%s: the longest mate is %d moves, expected %d", test.material, longest, test.moves)
+		}
+	}
+}
+
+// TestConsistency checks that the value of every position follows from the values after its moves
+func TestConsistency(t *testing.T) {
+	tb, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, material := range []string{"KRvK", "KPvK"} {
+		m, _ := parseMaterial(material)
+		table, err := tb.load(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := board.New()
+		for idx, v := range table.values {
+			if v == invalid || !m.position(idx, b) || m.index(b) != idx {
+				continue
+			}
+			if _, expected, ok := tb.search(b, false); !ok || v != expected {
+				t.Fatalf("%s: %s has value %d, but its moves lead to %d", material, b.FEN(), v, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/notnil/chess"
+	"github.com/razzie/blunder/engine"
+	"github.com/razzie/razchess/pkg/gamedb"
+	"github.com/razzie/razchess/pkg/razchess"
+)
+
+// moveStats are the results of a move from the point of view of the side that played it
+type moveStats struct {
+	games, wins, draws int
+}
+
+func (s *moveStats) score() int {
+	return 2*s.wins + s.draws
+}
+
+type book map[uint64]map[uint16]*moveStats
+
+func main() {
+	var output string
+	var minGames int
+	var minScore int
+	var maxPly int
+	var store string
+	var gameDB string
+	flag.StringVar(&output, "o", "book.bin", "Output Polyglot book")
+	flag.IntVar(&minGames, "min-games", 3, "Minimum number of games a move has to be played in")
+	flag.IntVar(&minScore, "min-score", 0, "Minimum score percentage of a move for the side that played it")
+	flag.IntVar(&maxPly, "max-ply", 40, "Number of plies to include from the start of the games")
+	flag.StringVar(&store, "store", "", "Optional storage of the server (Redis connection string or local directory) to include the archived games from")
+	flag.StringVar(&gameDB, "gamedb", "", "Optional game database of the server to include the games from")
+	flag.Parse()
+	if flag.NArg() == 0 && len(store) == 0 && len(gameDB) == 0 {
+		fmt.Printf("Usage: %s [-o book.bin] [-min-games n] [-min-score percent] [-max-ply n] [-store location] [-gamedb file] [PGN files...]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	b := make(book)
+	for _, filename := range flag.Args() {
+		pgn, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		count := 0
+		for _, game := range razchess.SplitPGN(string(pgn)) {
+			if b.addPGN(game, maxPly) {
+				count++
+			}
+		}
+		fmt.Println(filename, "-", count, "games")
+	}
+	if len(store) > 0 {
+		db, err := razchess.OpenStorage(store)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		count := 0
+		razchess.EachArchivedGame(db, func(game *razchess.ArchivedGame) {
+			if b.addPGN(game.PGN, maxPly) {
+				count++
+			}
+		})
+		fmt.Println(store, "-", count, "archived games")
+	}
+	if len(gameDB) > 0 {
+		db, err := gamedb.Open(gameDB)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		count := 0
+		for id := 0; id < db.Count(); id++ {
+			if game, ok := db.Game(id); ok && b.addPGN(game.PGN, maxPly) {
+				count++
+			}
+		}
+		fmt.Println(gameDB, "-", count, "games")
+	}
+
+	entries := b.entries(minGames, minScore)
+	if err := writeBook(output, entries); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(output, "-", len(entries), "moves in", countPositions(entries), "positions")
+}
+
+// addPGN adds the moves of a game and tells if it was a valid PGN
+func (b book) addPGN(pgn string, maxPly int) bool {
+	opt, err := chess.PGN(strings.NewReader(pgn))
+	if err != nil {
+		return false
+	}
+	b.addGame(chess.NewGame(opt), maxPly)
+	return true
+}
+
+func (b book) addGame(game *chess.Game, maxPly int) {
+	outcome := game.Outcome()
+	if outcome == chess.NoOutcome {
+		return
+	}
+	positions := game.Positions()
+	var pos engine.Position
+	for i, move := range game.Moves() {
+		if i >= maxPly {
+			break
+		}
+		pos.LoadFEN(positions[i].String())
+		hash := engine.GenPolyglotHash(&pos)
+		moves := b[hash]
+		if moves == nil {
+			moves = make(map[uint16]*moveStats)
+			b[hash] = moves
+		}
+		m := encodeMove(positions[i], move)
+		s := moves[m]
+		if s == nil {
+			s = &moveStats{}
+			moves[m] = s
+		}
+		s.games++
+		switch {
+		case outcome == chess.Draw:
+			s.draws++
+		case (outcome == chess.WhiteWon) == (positions[i].Turn() == chess.White):
+			s.wins++
+		}
+	}
+}
+
+// encodeMove returns the Polyglot encoding of a move (castling moves are encoded as the king capturing its rook)
+func encodeMove(pos *chess.Position, move *chess.Move) uint16 {
+	from, to := move.S1(), move.S2()
+	if move.HasTag(chess.KingSideCastle) {
+		to = chess.NewSquare(chess.FileH, from.Rank())
+	} else if move.HasTag(chess.QueenSideCastle) {
+		to = chess.NewSquare(chess.FileA, from.Rank())
+	}
+	var promo uint16
+	switch move.Promo() {
+	case chess.Knight:
+		promo = 1
+	case chess.Bishop:
+		promo = 2
+	case chess.Rook:
+		promo = 3
+	case chess.Queen:
+		promo = 4
+	}
+	return promo<<engine.PromotionPieceShift |
+		uint16(from.Rank())<<engine.FromRankShift |
+		uint16(from.File())<<engine.FromFileShift |
+		uint16(to.Rank())<<engine.ToRankShift |
+		uint16(to.File())
+}
+
+type entry struct {
+	key    uint64
+	move   uint16
+	weight uint16
+}
+
+// entries returns the moves that pass the filters sorted by position key and weight
+func (b book) entries(minGames, minScore int) []entry {
+	var entries []entry
+	for key, moves := range b {
+		maxScore := 0
+		for _, s := range moves {
+			if s.score() > maxScore {
+				maxScore = s.score()
+			}
+		}
+		for m, s := range moves {
+			if s.games < minGames || s.score()*50 < minScore*s.games || s.score() == 0 {
+				continue
+			}
+			weight := s.score()
+			if maxScore > 0xffff {
+				weight = weight * 0xffff / maxScore
+			}
+			if weight == 0 {
+				weight = 1
+			}
+			entries = append(entries, entry{key: key, move: m, weight: uint16(weight)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		if a.weight != b.weight {
+			return a.weight > b.weight
+		}
+		return a.move < b.move
+	})
+	return entries
+}
+
+func countPositions(entries []entry) (count int) {
+	for i := range entries {
+		if i == 0 || entries[i].key != entries[i-1].key {
+			count++
+		}
+	}
+	return
+}
+
+func writeBook(filename string, entries []entry) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var buf [engine.EntryByteLength]byte
+	for _, e := range entries {
+		binary.BigEndian.PutUint64(buf[0:8], e.key)
+		binary.BigEndian.PutUint16(buf[8:10], e.move)
+		binary.BigEndian.PutUint16(buf[10:12], e.weight)
+		binary.BigEndian.PutUint32(buf[12:16], 0) // learn
+		if _, err := w.Write(buf[:]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
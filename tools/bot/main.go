@@ -1,10 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
 	"github.com/razzie/razchess/pkg/connector"
+	"github.com/razzie/razchess/pkg/engine"
+	"github.com/razzie/razchess/pkg/razchess"
+	"github.com/razzie/razchess/pkg/tablebase"
 )
 
 const (
@@ -13,12 +17,33 @@ const (
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Printf("Usage: %s [w|b|w+b] [session URL]\n", os.Args[0])
+	var tablebaseDir string
+	var bookFilename string
+	flag.StringVar(&tablebaseDir, "tablebase", "", "Optional directory of the endgame tables for perfect endgame play")
+	flag.StringVar(&bookFilename, "book", "", "Optional Polyglot opening book (like the ones built by tools/bookbuild)")
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Printf("Usage: %s [-tablebase dir] [-book file] [w|b|w+b] [session URL]\n", os.Args[0])
 		os.Exit(1)
 	}
-	color := os.Args[1]
-	sessionURL := os.Args[2]
+	color := flag.Arg(0)
+	sessionURL := flag.Arg(1)
+
+	if len(tablebaseDir) > 0 {
+		tb, err := tablebase.Open(tablebaseDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		engine.UseTablebase(tb)
+	}
+
+	if len(bookFilename) > 0 {
+		if err := engine.UseBook(bookFilename); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	if color != "w" && color != "b" && color != "w+b" {
 		fmt.Println("invalid color:", color)
@@ -32,7 +57,7 @@ func main() {
 	}
 	defer conn.Close()
 
-	bot := NewBot(MoveTime, MaxDepth)
+	bot := engine.NewBot(MoveTime, MaxDepth)
 	for update := range conn.C {
 		if len(update.Opening) > 0 {
 			fmt.Println(update.FEN, "-", update.Opening, "-", update.Status)
@@ -43,7 +68,12 @@ func main() {
 			return
 		} else if update.Turn == color || color == "w+b" {
 			for {
-				bot.Update(update.FEN, update.PGN)
+				startingFEN, moves, err := razchess.ParsePGN(update.PGN)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				bot.Update(startingFEN, moves)
 				move := bot.BestMove()
 				fmt.Println("Best move:", move)
 				if conn.Move(move) {
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/razzie/razchess/pkg/tablebase"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Printf("Usage: %s [tablebase dir] [material signatures like KQvK KRvKP...]\n", os.Args[0])
+		os.Exit(1)
+	}
+	tb, err := tablebase.Open(os.Args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, material := range os.Args[2:] {
+		start := time.Now()
+		if err := tb.Generate(material); err != nil {
+			fmt.Println(material, "-", err)
+			os.Exit(1)
+		}
+		fmt.Println(material, "ready in", time.Since(start))
+	}
+}